@@ -26,6 +26,175 @@ type AWSSecretSpec struct {
 
 	// +optional
 	Metadata *SecretMeta `json:"metadata,omitempty"`
+
+	// Versioned makes the controller create an immutable Secret named `<name>-<hash>` per source version,
+	// instead of updating the Secret named after the AWSSecret in place.
+	// The name of the current Secret is published in `status.currentSecretName`.
+	// +optional
+	Versioned *VersionedSecrets `json:"versioned,omitempty"`
+
+	// FallbackRegions are the regions the secrets are replicated to, read in order when Secrets Manager in the primary region
+	// of the operator is unavailable. Defaults to the `--fallback-regions` of the operator.
+	// The region that served the values is published in `status.servedRegion`.
+	// +optional
+	FallbackRegions []string `json:"fallbackRegions,omitempty"`
+
+	// RestartWorkloads makes the controller trigger a rolling restart of the Deployments, StatefulSets and DaemonSets
+	// in the namespace that consume the Secret, whenever the synced content changes.
+	// Workloads that consume the Secret indirectly can opt in by listing the AWSSecret name in their
+	// `aws-secret-operator.mumoshu.github.io/restart-on-change` annotation.
+	// +optional
+	RestartWorkloads bool `json:"restartWorkloads,omitempty"`
+
+	// DeletionPolicy is either `Delete` or `Retain`. Defaults to `Delete`.
+	// `Delete` lets the managed Secrets be garbage-collected along with the AWSSecret.
+	// `Retain` strips the owner reference and the operator's labels from the managed Secrets when the AWSSecret is deleted,
+	// so that they survive the deletion.
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// CreationPolicy is one of `Owner`, `Adopt`, `Merge` and `None`. Defaults to `Owner`.
+	// `Owner` creates the Secret and fails when a Secret with the same name not owned by the AWSSecret already exists.
+	// `Adopt` takes over an existing Secret unless it is controlled by another object.
+	// `Merge` writes only the synced keys into an existing Secret, without taking ownership of it.
+	// `None` fetches the source data to validate it, without writing the Secret.
+	// +optional
+	CreationPolicy CreationPolicy `json:"creationPolicy,omitempty"`
+
+	// AdditionalStages makes the controller additionally emit the versions of the source secrets labelled with
+	// AWSPREVIOUS and AWSPENDING, so that clients can fail over between the old and the new credentials during rotations.
+	// +optional
+	AdditionalStages *AdditionalStages `json:"additionalStages,omitempty"`
+
+	// Provision makes the controller create the Secrets Manager secret referenced by the AWSSecret when it is missing,
+	// and keep its settings in sync with this spec.
+	// +optional
+	Provision *Provision `json:"provision,omitempty"`
+}
+
+// Provision defines the settings of the Secrets Manager secret provisioned by the controller.
+// Settings left empty are not managed by the controller.
+type Provision struct {
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// KmsKeyId is the ARN, key ID, or alias of the KMS key used to encrypt the secret
+	// +optional
+	KmsKeyId string `json:"kmsKeyId,omitempty"`
+
+	// Tags are added to the secret. Tags added by others are left intact.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// ReplicaRegions are the regions the secret is replicated to
+	// +optional
+	ReplicaRegions []ReplicaRegion `json:"replicaRegions,omitempty"`
+
+	// ResourcePolicy is the JSON resource-based policy attached to the secret
+	// +optional
+	ResourcePolicy string `json:"resourcePolicy,omitempty"`
+
+	// Rotation configures the automatic rotation of the secret
+	// +optional
+	Rotation *Rotation `json:"rotation,omitempty"`
+}
+
+// ReplicaRegion defines a region the secret is replicated to
+type ReplicaRegion struct {
+	Region string `json:"region"`
+
+	// KmsKeyId is the ARN, key ID, or alias of the KMS key used to encrypt the replica in the region
+	// +optional
+	KmsKeyId string `json:"kmsKeyId,omitempty"`
+}
+
+// Rotation defines how the secret is rotated.
+// See https://docs.aws.amazon.com/secretsmanager/latest/userguide/rotating-secrets.html
+type Rotation struct {
+	// LambdaARN is the ARN of the Lambda function that rotates the secret
+	LambdaARN string `json:"lambdaARN"`
+
+	// AutomaticallyAfterDays is the number of days between rotations
+	// +optional
+	AutomaticallyAfterDays int64 `json:"automaticallyAfterDays,omitempty"`
+
+	// ScheduleExpression is a `cron()` or `rate()` expression that defines the rotation schedule
+	// +optional
+	ScheduleExpression string `json:"scheduleExpression,omitempty"`
+
+	// Duration is the length of the rotation window in hours, like `3h`
+	// +optional
+	Duration string `json:"duration,omitempty"`
+}
+
+// AdditionalStages defines which additional versions of the source secrets are emitted and where
+type AdditionalStages struct {
+	// Labels are the staging labels of the versions to emit. Defaults to AWSPREVIOUS and AWSPENDING.
+	// +optional
+	Labels []StagingLabel `json:"labels,omitempty"`
+
+	// Output is either `Keys` or `Secrets`. Defaults to `Keys`.
+	// `Keys` emits the keys of each version into the Secret, suffixed with `_previous` or `_pending`.
+	// `Secrets` emits each version into a separate Secret named `<name>-previous` or `<name>-pending`.
+	// Nothing is emitted for a label while no version has it, and the separate Secret is deleted.
+	// +optional
+	Output AdditionalStagesOutput `json:"output,omitempty"`
+}
+
+// StagingLabel is a staging label of Secrets Manager secret versions
+// +kubebuilder:validation:Enum=AWSPREVIOUS;AWSPENDING
+type StagingLabel string
+
+const (
+	StagingLabelPrevious StagingLabel = "AWSPREVIOUS"
+	StagingLabelPending  StagingLabel = "AWSPENDING"
+)
+
+// AdditionalStagesOutput defines where the additional versions are emitted
+// +kubebuilder:validation:Enum=Keys;Secrets
+type AdditionalStagesOutput string
+
+const (
+	AdditionalStagesOutputKeys    AdditionalStagesOutput = "Keys"
+	AdditionalStagesOutputSecrets AdditionalStagesOutput = "Secrets"
+)
+
+// CreationPolicy defines how the controller deals with the target Secret
+// +kubebuilder:validation:Enum=Owner;Adopt;Merge;None
+type CreationPolicy string
+
+const (
+	CreationPolicyOwner CreationPolicy = "Owner"
+	CreationPolicyAdopt CreationPolicy = "Adopt"
+	CreationPolicyMerge CreationPolicy = "Merge"
+	CreationPolicyNone  CreationPolicy = "None"
+)
+
+// DeletionPolicy defines what happens to the managed Secrets when the AWSSecret is deleted
+// +kubebuilder:validation:Enum=Delete;Retain
+type DeletionPolicy string
+
+const (
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// VersionedSecrets configures how version-suffixed Secrets are published and garbage-collected
+type VersionedSecrets struct {
+	// HistoryLimit is the number of previous Secret generations to retain.
+	// Older generations are garbage-collected. Defaults to 2.
+	// +optional
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+
+	// PruneUnreferenced deletes previous Secret generations as soon as no Pod in the namespace references them,
+	// without waiting for them to fall out of the history limit.
+	// +optional
+	PruneUnreferenced bool `json:"pruneUnreferenced,omitempty"`
+
+	// PointerConfigMapName is the name of a ConfigMap the controller keeps up to date with the name of
+	// the current Secret under the `secretName` key.
+	// +optional
+	PointerConfigMapName string `json:"pointerConfigMapName,omitempty"`
 }
 
 type SecretMeta struct {
@@ -50,18 +219,244 @@ type SecretsManagerSecretRef struct {
 	SecretId string `json:"secretId,omitempty"`
 	// VersionIdis the VersionId a.k.a `--version-id` of the SecretsManager secret version
 	VersionId string `json:"versionId,omitempty"`
+	// VersionStage makes the controller follow the version labelled with the staging label, like AWSCURRENT,
+	// instead of a pinned VersionId. The controller polls DescribeSecret at the refresh interval and fetches
+	// the value only when the label has moved to another version. Ignored when VersionId is set.
+	// +optional
+	VersionStage string `json:"versionStage,omitempty"`
+	// Generate makes the controller create the SecretsManager secret with a random password when it does not exist.
+	// The secret is never regenerated once it exists.
+	// VersionId can be omitted to follow the AWSCURRENT version of the generated secret.
+	// +optional
+	Generate *Generator `json:"generate,omitempty"`
+}
+
+// Generator defines how the random password of a generated SecretsManager secret is built.
+// See https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_GetRandomPassword.html for the charset rules
+type Generator struct {
+	// Length is the length of the password. Defaults to 32.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4096
+	Length int64 `json:"length,omitempty"`
+
+	// ExcludeCharacters is the set of characters the password must not contain
+	// +optional
+	ExcludeCharacters string `json:"excludeCharacters,omitempty"`
+
+	// +optional
+	ExcludeNumbers bool `json:"excludeNumbers,omitempty"`
+
+	// +optional
+	ExcludePunctuation bool `json:"excludePunctuation,omitempty"`
+
+	// +optional
+	ExcludeUppercase bool `json:"excludeUppercase,omitempty"`
+
+	// +optional
+	ExcludeLowercase bool `json:"excludeLowercase,omitempty"`
+
+	// +optional
+	IncludeSpace bool `json:"includeSpace,omitempty"`
+
+	// Key makes the controller store the secret as a JSON object with the password under the key,
+	// instead of the plain password. Required for dataFrom, whose source must be a JSON object.
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Data is the static keys and values stored in the JSON object along with the password, like a username.
+	// Requires Key.
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
 }
 
 // AWSSecretStatus defines the observed state of AWSSecret
 type AWSSecretStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
+
+	// CurrentSecretName is the name of the Secret holding the latest synced data
+	// +optional
+	CurrentSecretName string `json:"currentSecretName,omitempty"`
+
+	// GeneratedGeneration is the generation of the AWSSecret the Secrets Manager secrets referenced with `generate`
+	// were last ensured to exist for. They are not looked up again until the generation changes.
+	// +optional
+	GeneratedGeneration int64 `json:"generatedGeneration,omitempty"`
+
+	// Provision is the observed state of the provisioned Secrets Manager secret
+	// +optional
+	Provision *ProvisionStatus `json:"provision,omitempty"`
+
+	// Rotation is the observed state of the rotation requested last via the
+	// `aws-secret-operator.mumoshu.github.io/rotate-requested-at` annotation
+	// +optional
+	Rotation *RotationStatus `json:"rotation,omitempty"`
+
+	// ServedRegion is the region of Secrets Manager that served the secret values synced last.
+	// It differs from the primary region of the operator when the values have been read from a fallback region.
+	// +optional
+	ServedRegion string `json:"servedRegion,omitempty"`
+
+	// SyncedGeneration is the generation of the AWSSecret the Secret was last synced for.
+	// The synced data is reused without reading the secret values only while it matches the generation.
+	// +optional
+	SyncedGeneration int64 `json:"syncedGeneration,omitempty"`
+
+	// RestartedHash is the content hash of the Secret the workloads were last restarted for with `spec.restartWorkloads`.
+	// The restarts are retried until it matches the content hash of the applied Secret.
+	// +optional
+	RestartedHash string `json:"restartedHash,omitempty"`
+
+	// StageSecretVersions are the VersionIds of the additional versions last written into separate Secrets,
+	// in the same format as the `aws-secret-operator.mumoshu.github.io/source-versions` annotation.
+	// The secret values of the additional versions are read only when they change.
+	// +optional
+	StageSecretVersions string `json:"stageSecretVersions,omitempty"`
+
+	// VersionsCheckedAt is the time the pinned versions were last checked for staleness.
+	// They are checked again after the staleness check interval, or when the spec changes.
+	// +optional
+	VersionsCheckedAt *metav1.Time `json:"versionsCheckedAt,omitempty"`
+
+	// LastForceSync is the value of the `aws-secret-operator.mumoshu.github.io/force-sync` annotation
+	// the Secret was last force-synced for
+	// +optional
+	LastForceSync string `json:"lastForceSync,omitempty"`
+
+	// Conditions represent the latest available observations of the AWSSecret's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ProvisionStatus defines the observed state of the provisioned Secrets Manager secret
+type ProvisionStatus struct {
+	// ARN is the ARN of the provisioned secret
+	// +optional
+	ARN string `json:"arn,omitempty"`
+
+	// Drift lists the settings found out of sync with the spec and corrected by the last reconciliation
+	// +optional
+	Drift []string `json:"drift,omitempty"`
+
+	// CheckedAt is the time the settings were last checked for drift.
+	// They are checked again after the drift check interval, or when the spec changes.
+	// +optional
+	CheckedAt *metav1.Time `json:"checkedAt,omitempty"`
+}
+
+// RotationPhase is the phase of a rotation requested by the AWSSecret
+type RotationPhase string
+
+const (
+	// RotationPending means the new version is still labelled AWSPENDING
+	RotationPending RotationPhase = "Pending"
+	// RotationSucceeded means the new version has been promoted to AWSCURRENT
+	RotationSucceeded RotationPhase = "Succeeded"
+	// RotationFailed means the rotation couldn't be started or the new version never became AWSCURRENT
+	RotationFailed RotationPhase = "Failed"
+)
+
+// RotationStatus defines the observed state of a rotation requested by the AWSSecret
+type RotationStatus struct {
+	// RequestedAt is the value of the rotate-requested-at annotation that triggered the rotation
+	RequestedAt string `json:"requestedAt"`
+
+	// VersionId is the Secrets Manager secret version created by the rotation
+	// +optional
+	VersionId string `json:"versionId,omitempty"`
+
+	Phase RotationPhase `json:"phase"`
+
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+const (
+	// ConditionReady indicates whether the Secret is in sync with the source
+	ConditionReady = "Ready"
+	// ConditionProvisioned indicates whether the Secrets Manager secret is in sync with spec.provision
+	ConditionProvisioned = "Provisioned"
+	// ConditionVersionStale indicates whether a pinned versionId is no longer AWSCURRENT
+	ConditionVersionStale = "VersionStale"
+	// ConditionRotated indicates whether the rotation requested last has completed
+	ConditionRotated = "Rotated"
+	// ConditionPaused indicates whether the controller is refraining from writing the Secret and the Secrets Manager secret
+	ConditionPaused = "Paused"
+	// ConditionQuotaExceeded indicates whether the controller is refraining from syncing the AWSSecret
+	// because its namespace is over quota
+	ConditionQuotaExceeded = "QuotaExceeded"
+)
+
+const (
+	// ReasonOwned means the Secret is created and owned by the AWSSecret
+	ReasonOwned = "Owned"
+	// ReasonAdopted means an existing Secret has been adopted by the AWSSecret
+	ReasonAdopted = "Adopted"
+	// ReasonMerged means the synced keys have been merged into an existing Secret not owned by the AWSSecret
+	ReasonMerged = "Merged"
+	// ReasonValidated means the source data has been fetched without writing the Secret
+	ReasonValidated = "Validated"
+	// ReasonForeignSecretExists means a Secret not owned by the AWSSecret prevented the controller from writing it
+	ReasonForeignSecretExists = "ForeignSecretExists"
+	// ReasonSecretNotFound means there is no Secret to merge the synced keys into
+	ReasonSecretNotFound = "SecretNotFound"
+	// ReasonCreated means the Secrets Manager secret has been created
+	ReasonCreated = "Created"
+	// ReasonInSync means the Secrets Manager secret was already in sync with the spec
+	ReasonInSync = "InSync"
+	// ReasonDriftCorrected means settings of the Secrets Manager secret drifted from the spec and have been corrected
+	ReasonDriftCorrected = "DriftCorrected"
+	// ReasonProvisionFailed means the controller failed to create or update the Secrets Manager secret
+	ReasonProvisionFailed = "ProvisionFailed"
+	// ReasonGenerateFailed means the controller failed to generate the missing Secrets Manager secret
+	ReasonGenerateFailed = "GenerateFailed"
+	// ReasonRotationPending means the rotation has been started and the new version isn't AWSCURRENT yet
+	ReasonRotationPending = "RotationPending"
+	// ReasonRotationSucceeded means the new version created by the rotation has become AWSCURRENT
+	ReasonRotationSucceeded = "RotationSucceeded"
+	// ReasonRotationFailed means the rotation couldn't be started, or the rotation Lambda failed to promote the new version
+	ReasonRotationFailed = "RotationFailed"
+	// ReasonVersionCurrent means the pinned versions are AWSCURRENT
+	ReasonVersionCurrent = "VersionCurrent"
+	// ReasonVersionNotCurrent means a pinned version has staging labels but AWSCURRENT has moved to a newer version
+	ReasonVersionNotCurrent = "VersionNotCurrent"
+	// ReasonVersionDeprecated means a pinned version has no staging labels and can be deleted by Secrets Manager at any time
+	ReasonVersionDeprecated = "VersionDeprecated"
+	// ReasonVersionDeleted means a pinned version no longer exists
+	ReasonVersionDeleted = "VersionDeleted"
+	// ReasonPausedByAnnotation means the AWSSecret is paused by the `aws-secret-operator.mumoshu.github.io/paused` annotation
+	ReasonPausedByAnnotation = "PausedByAnnotation"
+	// ReasonPausedGlobally means the operator is running with `--paused`
+	ReasonPausedGlobally = "PausedGlobally"
+	// ReasonResumed means the AWSSecret is synced again after it was paused
+	ReasonResumed = "Resumed"
+	// ReasonAWSSecretCountExceeded means the namespace has more AWSSecrets than its quota, and the AWSSecret is not among the oldest ones
+	ReasonAWSSecretCountExceeded = "AWSSecretCountExceeded"
+	// ReasonAWSCallBudgetExceeded means the namespace has used up its budget of Secrets Manager API calls for now
+	ReasonAWSCallBudgetExceeded = "AWSCallBudgetExceeded"
+	// ReasonWithinQuota means the AWSSecret is synced again after its namespace was over quota
+	ReasonWithinQuota = "WithinQuota"
+	// ReasonUnsupportedCreationPolicy means the creation policy can't be used in combination with the other settings
+	ReasonUnsupportedCreationPolicy = "UnsupportedCreationPolicy"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // AWSSecret is the Schema for the awssecrets API
 // +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.status.currentSecretName`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
 type AWSSecret struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,108 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PushSecretSpec defines the desired state of PushSecret
+type PushSecretSpec struct {
+	// SecretRef is the Kubernetes Secret in the same namespace whose data is pushed to Secrets Manager
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// Keys limits the pushed keys of the Kubernetes Secret. Defaults to all the keys.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+
+	// SecretsManagerSecret defines the Secrets Manager secret the data is pushed to.
+	// The data is pushed as a JSON object of the Kubernetes Secret keys and values.
+	SecretsManagerSecret PushSecretTarget `json:"secretsManagerSecret"`
+
+	// Overwrite allows the controller to push to a Secrets Manager secret that it didn't create
+	// +optional
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+// PushSecretTarget defines the Secrets Manager secret the data is pushed to.
+// KmsKeyId, Description and Tags are used only when the controller creates the secret.
+type PushSecretTarget struct {
+	// SecretId is the name or the ARN of the Secrets Manager secret
+	SecretId string `json:"secretId"`
+
+	// KmsKeyId is the ARN, key ID, or alias of the KMS key used to encrypt the secret.
+	// Defaults to the AWS managed key `aws/secretsmanager`.
+	// +optional
+	KmsKeyId string `json:"kmsKeyId,omitempty"`
+
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// PushSecretStatus defines the observed state of PushSecret
+type PushSecretStatus struct {
+	// ARN is the ARN of the Secrets Manager secret
+	// +optional
+	ARN string `json:"arn,omitempty"`
+
+	// VersionId is the Secrets Manager secret version created by the last push
+	// +optional
+	VersionId string `json:"versionId,omitempty"`
+
+	// ContentHash is the HMAC-SHA256 of the data pushed last, keyed with the UID of the PushSecret and the operator's hash key
+	// +optional
+	ContentHash string `json:"contentHash,omitempty"`
+
+	// ObservedGeneration is the generation of the PushSecret pushed last
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the PushSecret's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ReasonPushed means the data has been pushed to Secrets Manager
+	ReasonPushed = "Pushed"
+	// ReasonPushFailed means the controller failed to push the data to Secrets Manager
+	ReasonPushFailed = "PushFailed"
+	// ReasonNotManaged means the Secrets Manager secret wasn't created by the PushSecret and overwrite isn't allowed
+	ReasonNotManaged = "NotManaged"
+	// ReasonKeyNotFound means one of the keys to push is missing in the Kubernetes Secret
+	ReasonKeyNotFound = "KeyNotFound"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PushSecret is the Schema for the pushsecrets API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.spec.secretRef.name`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.versionId`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+type PushSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PushSecretSpec   `json:"spec,omitempty"`
+	Status PushSecretStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PushSecretList contains a list of PushSecret
+type PushSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PushSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PushSecret{}, &PushSecretList{})
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// namespacePollInterval is how often the namespaces matching the watch namespace selector are listed
+const namespacePollInterval = 30 * time.Second
+
+// getWatchNamespaces returns the Namespaces the operator should be watching for changes,
+// from the comma-separated list in WATCH_NAMESPACE. Nil means the operator is running with cluster scope.
+// See https://github.com/operator-framework/operator-sdk/blob/a05f966806f1beaac3c45d28072f107a502ab253/website/content/en/docs/building-operators/golang/operator-scope.md#configuring-namespace-scoped-operators
+func getWatchNamespaces() ([]string, error) {
+	// WatchNamespaceEnvVar is the constant for env variable WATCH_NAMESPACE
+	// which specifies the Namespace to watch.
+	// An empty value means the operator is running with cluster scope.
+	var watchNamespaceEnvVar = "WATCH_NAMESPACE"
+
+	v, found := os.LookupEnv(watchNamespaceEnvVar)
+	if !found {
+		return nil, fmt.Errorf("%s must be set", watchNamespaceEnvVar)
+	}
+
+	return splitNamespaces(v), nil
+}
+
+// splitNamespaces returns the sorted, unique namespaces in the comma-separated list
+func splitNamespaces(v string) []string {
+	seen := map[string]bool{}
+
+	var namespaces []string
+	for _, ns := range strings.Split(v, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+// namespacesMatching returns the sorted names of the namespaces matching the selector
+func namespacesMatching(ctx context.Context, c client.Reader, selector labels.Selector) ([]string, error) {
+	var list corev1.NamespaceList
+	if err := c.List(ctx, &list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	namespaces := []string{}
+	for _, ns := range list.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+
+	sort.Strings(namespaces)
+
+	return namespaces, nil
+}
+
+var _ manager.Runnable = &namespaceWatcher{}
+var _ manager.LeaderElectionRunnable = &namespaceWatcher{}
+
+// namespaceWatcher polls the namespaces matching the watch namespace selector, and calls OnChange when
+// namespaces start or stop matching, so that the manager is restarted with the cache for the new namespaces.
+// A restart relists every cached object, so OnChange is called only after the matching namespaces have stayed the same
+// for Debounce, to restart once for a burst of namespace changes.
+type namespaceWatcher struct {
+	Reader     client.Reader
+	Selector   labels.Selector
+	Namespaces []string
+	Debounce   time.Duration
+	OnChange   func()
+	Log        logr.Logger
+
+	pending      []string
+	pendingSince time.Time
+}
+
+// NeedLeaderElection returns false so that all the replicas restart with the new namespaces
+func (w *namespaceWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *namespaceWatcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(namespacePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		namespaces, err := namespacesMatching(ctx, w.Reader, w.Selector)
+		if err != nil {
+			w.Log.Error(err, "Failed to list namespaces", "selector", w.Selector.String())
+			continue
+		}
+
+		if w.observe(namespaces, time.Now()) {
+			w.Log.Info("Watched namespaces changed", "selector", w.Selector.String(), "old", w.Namespaces, "new", namespaces)
+			w.OnChange()
+			return nil
+		}
+	}
+}
+
+// observe records the matching namespaces, and returns true once they have differed from the watched ones
+// and stayed the same for the debounce period
+func (w *namespaceWatcher) observe(namespaces []string, now time.Time) bool {
+	if reflect.DeepEqual(namespaces, w.Namespaces) {
+		w.pending = nil
+		return false
+	}
+
+	if w.pending == nil || !reflect.DeepEqual(namespaces, w.pending) {
+		w.Log.Info("Watched namespaces changing, waiting for them to settle", "selector", w.Selector.String(), "new", namespaces, "debounce", w.Debounce)
+		w.pending = namespaces
+		w.pendingSince = now
+	}
+
+	return now.Sub(w.pendingSince) >= w.Debounce
+}
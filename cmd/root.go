@@ -7,19 +7,30 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/exec"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/mumoshu/aws-secret-operator/api"
 	"github.com/mumoshu/aws-secret-operator/controllers"
-	"github.com/operator-framework/operator-lib/leader"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	zaplib "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -45,13 +56,51 @@ const (
 )
 
 type OperateOpts struct {
-	ConfigMapName      string
-	ConfigMapNamespace string
-	WatchNamespace     string
+	ConfigMapName          string
+	ConfigMapNamespace     string
+	WatchNamespace         string
+	WatchNamespaceSelector string
+	WatchNamespaceDebounce time.Duration
+	ForceOwnership         bool
+	RefreshInterval        time.Duration
+	StalenessCheckInterval time.Duration
+	DriftCheckInterval     time.Duration
+	Paused                 bool
+	PushSecrets            bool
+
+	MaxConcurrentReconciles int
+	AWSAPIQPS               float64
+	AWSAPIBurst             int
+	QuotaConfig             string
+	FallbackRegions         []string
+
+	EventReceiverBindAddress  string
+	EventReceiverSNSTopicARNs []string
+	EventReceiverTLSCertFile  string
+	EventReceiverTLSKeyFile   string
+	SQSQueueURL               string
+
+	LeaderElect                 bool
+	LeaderElectionID            string
+	LeaderElectionNamespace     string
+	LeaderElectionLeaseDuration time.Duration
+	LeaderElectionRenewDeadline time.Duration
+	LeaderElectionRetryPeriod   time.Duration
+
+	Sharding           bool
+	ShardGroup         string
+	ShardLeaseDuration time.Duration
+	ShardRenewInterval time.Duration
 }
 
 var opts = OperateOpts{}
 
+// eventReceiverHMACKeyEnvVar is the env var holding the shared key events delivered to the event receiver are signed with
+const eventReceiverHMACKeyEnvVar = "EVENT_RECEIVER_HMAC_KEY"
+
+// pushSecretHashKeyEnvVar is the env var holding the key the content hashes of the data pushed by PushSecrets are keyed with
+const pushSecretHashKeyEnvVar = "PUSH_SECRET_HASH_KEY"
+
 var Root = &cobra.Command{
 	Use:   "aws-secret-operator",
 	Short: "Creates and updates Kubernetes secrets based on secrets stored in AWS Secrets Manager",
@@ -66,6 +115,34 @@ func init() {
 	Root.Flags().StringVar(&opts.ConfigMapName, "configmap-name", "falco-operator", "the name of the configmap to which this operator writes the concatenated falco rules")
 	Root.Flags().StringVarP(&opts.ConfigMapNamespace, "configmap-namespace", "n", "kube-system", "namespace in which falco and falco-operator are running")
 	Root.Flags().StringVarP(&opts.WatchNamespace, "watch-namespace", "w", "", "namespaces on which the operator watches for changes")
+	Root.Flags().StringVar(&opts.WatchNamespaceSelector, "watch-namespace-selector", "", "the label selector of the namespaces to watch, like aws-secret-operator=enabled. Namespaces starting or stopping to match are picked up by restarting the controllers in-process, which relists every cached object but keeps the scheduled resyncs of the synced awssecrets. Requires WATCH_NAMESPACE to be empty")
+	Root.Flags().DurationVar(&opts.WatchNamespaceDebounce, "watch-namespace-debounce", 2*time.Minute, "how long the namespaces matching --watch-namespace-selector must stay the same before the controllers are restarted to watch them, so that a burst of namespace changes results in a single restart")
+	Root.Flags().DurationVar(&opts.RefreshInterval, "refresh-interval", 5*time.Minute, "the interval at which awssecrets are resynced and the secrets pushed by pushsecrets are checked for changes. Secrets Manager secrets followed by versionStage are polled with DescribeSecret at this interval")
+	Root.Flags().DurationVar(&opts.StalenessCheckInterval, "staleness-check-interval", time.Hour, "the interval at which the versions pinned by awssecrets are checked for staleness with ListSecretVersionIds. They are also checked when the spec of the awssecret changes")
+	Root.Flags().DurationVar(&opts.DriftCheckInterval, "drift-check-interval", time.Hour, "the interval at which the secrets manager secrets provisioned by awssecrets are checked for drift with DescribeSecret and GetResourcePolicy. They are also checked when the spec of the awssecret changes")
+	Root.Flags().StringVar(&opts.EventReceiverBindAddress, "event-receiver-bind-address", "", "the address the http receiver of secrets manager change events from eventbridge listens on, like :8443. Disabled when empty. Raw events must be signed with the hmac key in the "+eventReceiverHMACKeyEnvVar+" env var")
+	Root.Flags().StringVar(&opts.EventReceiverTLSCertFile, "event-receiver-tls-cert-file", "", "the path to the certificate the event receiver serves https with. The event receiver serves plain http when empty. Requires --event-receiver-tls-key-file")
+	Root.Flags().StringVar(&opts.EventReceiverTLSKeyFile, "event-receiver-tls-key-file", "", "the path to the private key of --event-receiver-tls-cert-file")
+	Root.Flags().StringSliceVar(&opts.EventReceiverSNSTopicARNs, "event-receiver-sns-topic-arns", nil, "the arns of the sns topics the event receiver accepts notifications from")
+	Root.Flags().StringVar(&opts.SQSQueueURL, "sqs-queue-url", "", "the url of the sqs queue fed by eventbridge rules for secrets manager events, to resync the affected awssecrets immediately. Disabled when empty")
+	Root.Flags().IntVar(&opts.MaxConcurrentReconciles, "max-concurrent-reconciles", 1, "the maximum number of awssecrets reconciled concurrently")
+	Root.Flags().Float64Var(&opts.AWSAPIQPS, "aws-api-qps", 10, "the maximum number of secrets manager api calls per second across all the reconciliations. Unlimited when 0")
+	Root.Flags().IntVar(&opts.AWSAPIBurst, "aws-api-burst", 10, "the maximum number of secrets manager api calls made at once before --aws-api-qps applies")
+	Root.Flags().StringSliceVar(&opts.FallbackRegions, "fallback-regions", nil, "the regions secrets manager secrets are replicated to, read in order when secrets manager in the region of the operator is unavailable. Overridden by spec.fallbackRegions of awssecrets")
+	Root.Flags().StringVar(&opts.QuotaConfig, "quota-config", "", "the path to the yaml file of the per-namespace quotas on the number of awssecrets and the secrets manager api calls. Unlimited when empty")
+	Root.Flags().BoolVar(&opts.PushSecrets, "push-secrets", true, "run the pushsecret controller, which watches the metadata of all the secrets in the watched namespaces without their annotations. Disable to not watch unmanaged secrets at all")
+	Root.Flags().BoolVar(&opts.Paused, "paused", false, "skip writing secrets and secrets manager secrets for all awssecrets, reporting the Paused condition instead. Useful to freeze all the secrets during incidents")
+	Root.Flags().BoolVar(&opts.LeaderElect, "leader-elect", true, "elect the leader among the replicas of the operator with a lease, so that only the leader reconciles. Disable when running the operator out of cluster")
+	Root.Flags().StringVar(&opts.LeaderElectionID, "leader-election-id", "aws-secret-operator-lock", "the name of the lease used for leader election")
+	Root.Flags().StringVar(&opts.LeaderElectionNamespace, "leader-election-namespace", "", "the namespace of the lease used for leader election. Defaults to the namespace of the pod")
+	Root.Flags().DurationVar(&opts.LeaderElectionLeaseDuration, "leader-election-lease-duration", 15*time.Second, "the duration non-leader replicas wait before taking over the lease not renewed by the leader")
+	Root.Flags().DurationVar(&opts.LeaderElectionRenewDeadline, "leader-election-renew-deadline", 10*time.Second, "the duration the leader retries renewing the lease before giving up the leadership")
+	Root.Flags().DurationVar(&opts.LeaderElectionRetryPeriod, "leader-election-retry-period", 2*time.Second, "the interval at which replicas try to acquire or renew the lease")
+	Root.Flags().BoolVar(&opts.Sharding, "sharding", false, "run all the replicas active, each reconciling the shard of awssecrets assigned to it by consistent hashing. Replaces leader election. Shards rebalance automatically when replicas join or leave")
+	Root.Flags().StringVar(&opts.ShardGroup, "shard-group", "aws-secret-operator", "the name of the group of replicas sharing the awssecrets. The lease of each replica is created in the leader election namespace")
+	Root.Flags().DurationVar(&opts.ShardLeaseDuration, "shard-lease-duration", 15*time.Second, "the duration after which the awssecrets of a replica not renewing its lease are taken over by the other replicas")
+	Root.Flags().DurationVar(&opts.ShardRenewInterval, "shard-renew-interval", 5*time.Second, "the interval at which replicas renew their leases and check for joining or leaving replicas")
+	Root.Flags().BoolVar(&opts.ForceOwnership, "force-ownership", false, "take over fields of managed secrets owned by other field managers on server-side apply conflicts. Useful when migrating secrets written by older versions of the operator")
 }
 
 func run() error {
@@ -107,68 +184,280 @@ func run() error {
 
 	printVersion()
 
-	namespace, err := getWatchNamespace()
+	namespaces, err := getWatchNamespaces()
 	if err != nil {
 		return errors.Wrap(err, "failed to get watch namespace")
 	}
 
+	var selector labels.Selector
+	if opts.WatchNamespaceSelector != "" {
+		if len(namespaces) > 0 {
+			return errors.New("--watch-namespace-selector can't be combined with WATCH_NAMESPACE")
+		}
+
+		selector, err = labels.Parse(opts.WatchNamespaceSelector)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse --watch-namespace-selector")
+		}
+	}
+
+	if (opts.EventReceiverTLSCertFile == "") != (opts.EventReceiverTLSKeyFile == "") {
+		return errors.New("--event-receiver-tls-cert-file and --event-receiver-tls-key-file must be set together")
+	}
+
 	// Get a config to talk to the apiserver
 	cfg, err := config.GetConfig()
 	if err != nil {
 		return errors.Wrap(err, "failed to get config")
 	}
 
-	// Become the leader before proceeding
-	err = leader.Become(context.TODO(), "aws-secret-operator-lock")
-	if err != nil {
-		return errors.Wrap(err, "failed to became the leader")
+	ctx := signals.SetupSignalHandler()
+
+	// Shared by the managers restarted on namespace changes, so that the resyncs scheduled before a restart are kept
+	queue := controllers.NewPriorityQueue(opts.MaxConcurrentReconciles)
+
+	for {
+		if selector != nil {
+			c, err := client.New(cfg, client.Options{})
+			if err != nil {
+				return errors.Wrap(err, "failed to init client")
+			}
+
+			namespaces, err = namespacesMatching(ctx, c, selector)
+			if err != nil {
+				return errors.Wrap(err, "failed to list namespaces matching the watch namespace selector")
+			}
+		}
+
+		restart, err := runManager(ctx, cfg, namespaces, selector, queue)
+		if err != nil {
+			return err
+		}
+
+		if !restart {
+			return nil
+		}
+
+		log.Info("Restarting the Cmd to watch the changed namespaces.")
 	}
+}
 
-	// Create a new Cmd to provide shared dependencies and start components
-	mgr, err := manager.New(cfg, manager.Options{Namespace: namespace})
-	if err != nil {
-		return errors.Wrap(err, "failed to init manager")
+// runManager runs the manager with the controllers watching the namespaces until the context is done,
+// or the namespaces matching the selector change. It returns true in the latter case.
+// The manager watches the whole cluster when both the namespaces and the selector are nil.
+// The AWSSecret controller holds back the periodic resyncs in the queue.
+func runManager(ctx context.Context, cfg *rest.Config, namespaces []string, selector labels.Selector, queue *controllers.PriorityQueue) (bool, error) {
+	newCache := cache.New
+
+	mgrOpts := manager.Options{
+		LeaderElection:                opts.LeaderElect,
+		LeaderElectionResourceLock:    resourcelock.LeasesResourceLock,
+		LeaderElectionID:              opts.LeaderElectionID,
+		LeaderElectionNamespace:       opts.LeaderElectionNamespace,
+		LeaderElectionReleaseOnCancel: true,
+		LeaseDuration:                 &opts.LeaderElectionLeaseDuration,
+		RenewDeadline:                 &opts.LeaderElectionRenewDeadline,
+		RetryPeriod:                   &opts.LeaderElectionRetryPeriod,
+	}
+
+	if opts.Sharding {
+		// Every replica is active and reconciles its own shard
+		mgrOpts.LeaderElection = false
+	}
+
+	if len(namespaces) == 1 {
+		mgrOpts.Namespace = namespaces[0]
+	} else if len(namespaces) > 1 || selector != nil {
+		// A selector matching no namespaces results in a cache without any namespace, instead of the cluster-wide cache
+		newCache = cache.MultiNamespacedCacheBuilder(namespaces)
 	}
 
+	mgrOpts.NewCache = controllers.ManagedSecretsOnly(newCache)
+
+	log.Info("Watching namespaces", "namespaces", namespaces)
+
+	// Create a new Cmd to provide shared dependencies and start components.
+	// Controllers and runnables start only after the replica becomes the leader.
+	mgr, err := manager.New(cfg, mgrOpts)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to init manager")
+	}
 	log.Info("Registering Components.")
 
 	// Setup Scheme for all resources
 	if err := api.AddToScheme(mgr.GetScheme()); err != nil {
-		return errors.Wrap(err, "failed to add apis to scheme")
+		return false, errors.Wrap(err, "failed to add apis to scheme")
 	}
 
 	// Setup all Controllers
 
+	// The AWS session, the Secrets Manager client and its rate limiter are shared by all the controllers and the concurrent reconciliations
+	sess := session.Must(session.NewSession())
+
+	var limiter *rate.Limiter
+	if opts.AWSAPIQPS > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.AWSAPIQPS), opts.AWSAPIBurst)
+	}
+
+	syncContext := controllers.NewSyncContext(sess, limiter)
+
+	var events chan event.GenericEvent
+	if opts.EventReceiverBindAddress != "" || opts.SQSQueueURL != "" || opts.Sharding {
+		events = make(chan event.GenericEvent, 1024)
+	}
+
+	var quotas *controllers.Quotas
+	if opts.QuotaConfig != "" {
+		quotas, err = controllers.LoadQuotas(opts.QuotaConfig)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to load quotas")
+		}
+	}
+
 	awsSecretController := &controllers.AWSSecretController{
-		Scheme: mgr.GetScheme(),
-		Client: mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		APIReader:       mgr.GetAPIReader(),
+		Recorder:        mgr.GetEventRecorderFor("aws-secret-operator"),
+		ForceOwnership:  opts.ForceOwnership,
+		RefreshInterval: opts.RefreshInterval,
+		Paused:          opts.Paused,
+		Events:          events,
+
+		StalenessCheckInterval: opts.StalenessCheckInterval,
+		DriftCheckInterval:     opts.DriftCheckInterval,
+
+		MaxConcurrentReconciles: opts.MaxConcurrentReconciles,
+		PriorityQueue:           queue,
+		Quotas:                  quotas,
+		FallbackRegions:         opts.FallbackRegions,
+		SyncContext:             syncContext,
+	}
+
+	var (
+		shard            *controllers.Shard
+		pushSecretEvents chan event.GenericEvent
+	)
+
+	if opts.Sharding {
+		if opts.PushSecrets {
+			pushSecretEvents = make(chan event.GenericEvent, 1024)
+		}
+
+		shard, err = newShard(mgr, events, pushSecretEvents)
+		if err != nil {
+			return false, err
+		}
+
+		if err := mgr.Add(shard); err != nil {
+			return false, errors.Wrap(err, "failed to add shard to manager")
+		}
+
+		awsSecretController.Shard = shard
 	}
 
 	if err := awsSecretController.SetupWithManager(mgr); err != nil {
-		return errors.Wrap(err, "failed to add controller(s) to manager")
+		return false, errors.Wrap(err, "failed to add controller(s) to manager")
 	}
 
-	log.Info("Starting the Cmd.")
+	if opts.PushSecrets {
+		// Unlike the manager cache, watches the metadata of the Secrets without the managed-by label, which PushSecrets push
+		metadataClient, err := metadata.NewForConfig(cfg)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to init metadata client")
+		}
 
-	// Start the Cmd
-	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
-		return errors.Wrap(err, "manager exited non-zero")
+		informerNamespaces := namespaces
+		if informerNamespaces == nil && selector == nil {
+			informerNamespaces = []string{metav1.NamespaceAll}
+		}
+
+		secretMetadataInformers := []*controllers.SecretMetadataInformer{}
+		for _, ns := range informerNamespaces {
+			i := controllers.NewSecretMetadataInformer(metadataClient, ns)
+			if err := mgr.Add(i); err != nil {
+				return false, errors.Wrap(err, "failed to add secret metadata informer to manager")
+			}
+			secretMetadataInformers = append(secretMetadataInformers, i)
+		}
+
+		pushSecretController := &controllers.PushSecretController{
+			Scheme:          mgr.GetScheme(),
+			Client:          mgr.GetClient(),
+			APIReader:       mgr.GetAPIReader(),
+			HashKey:         []byte(os.Getenv(pushSecretHashKeyEnvVar)),
+			RefreshInterval: opts.RefreshInterval,
+			Shard:           shard,
+			Events:          pushSecretEvents,
+			SyncContext:     syncContext,
+
+			SecretMetadataInformers: secretMetadataInformers,
+		}
+
+		if err := pushSecretController.SetupWithManager(mgr); err != nil {
+			return false, errors.Wrap(err, "failed to add controller(s) to manager")
+		}
 	}
 
-	return nil
-}
+	if opts.EventReceiverBindAddress != "" {
+		receiver := &controllers.EventReceiver{
+			BindAddress:  opts.EventReceiverBindAddress,
+			TLSCertFile:  opts.EventReceiverTLSCertFile,
+			TLSKeyFile:   opts.EventReceiverTLSKeyFile,
+			HMACKey:      []byte(os.Getenv(eventReceiverHMACKeyEnvVar)),
+			SNSTopicARNs: opts.EventReceiverSNSTopicARNs,
+			// Runs on every replica, forwarding the AWSSecrets to the leader until this replica is elected
+			Enqueuer: &controllers.SecretEventEnqueuer{Client: mgr.GetClient(), Events: events, Shard: shard, Elected: mgr.Elected()},
+			Log:      logf.Log.WithName("event-receiver"),
+		}
+
+		if err := mgr.Add(receiver); err != nil {
+			return false, errors.Wrap(err, "failed to add event receiver to manager")
+		}
+	}
+
+	if opts.SQSQueueURL != "" {
+		poller := &controllers.SQSPoller{
+			QueueURL: opts.SQSQueueURL,
+			SQS:      sqs.New(sess),
+			Enqueuer: &controllers.SecretEventEnqueuer{Client: mgr.GetClient(), Events: events, Shard: shard},
+			Log:      logf.Log.WithName("sqs-poller"),
+		}
+
+		if err := mgr.Add(poller); err != nil {
+			return false, errors.Wrap(err, "failed to add sqs poller to manager")
+		}
+	}
+
+	mgrCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var restart atomic.Bool
 
-// getWatchNamespace returns the Namespace the operator should be watching for changes
-// See https://github.com/operator-framework/operator-sdk/blob/a05f966806f1beaac3c45d28072f107a502ab253/website/content/en/docs/building-operators/golang/operator-scope.md#configuring-namespace-scoped-operators
-func getWatchNamespace() (string, error) {
-	// WatchNamespaceEnvVar is the constant for env variable WATCH_NAMESPACE
-	// which specifies the Namespace to watch.
-	// An empty value means the operator is running with cluster scope.
-	var watchNamespaceEnvVar = "WATCH_NAMESPACE"
+	if selector != nil {
+		watcher := &namespaceWatcher{
+			Reader:     mgr.GetAPIReader(),
+			Selector:   selector,
+			Namespaces: namespaces,
+			Debounce:   opts.WatchNamespaceDebounce,
+			OnChange: func() {
+				restart.Store(true)
+				cancel()
+			},
+			Log: logf.Log.WithName("namespace-watcher"),
+		}
 
-	ns, found := os.LookupEnv(watchNamespaceEnvVar)
-	if !found {
-		return "", fmt.Errorf("%s must be set", watchNamespaceEnvVar)
+		if err := mgr.Add(watcher); err != nil {
+			return false, errors.Wrap(err, "failed to add namespace watcher to manager")
+		}
 	}
-	return ns, nil
+
+	log.Info("Starting the Cmd.")
+
+	// Start the Cmd
+	if err := mgr.Start(mgrCtx); err != nil {
+		return false, errors.Wrap(err, "manager exited non-zero")
+	}
+
+	return restart.Load() && ctx.Err() == nil, nil
 }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/mumoshu/aws-secret-operator/controllers"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// serviceAccountNamespaceFile is where the namespace of the pod is mounted in-cluster
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// newShard returns the shard of this replica, identified by the pod name, with the Lease in the leader election namespace
+func newShard(mgr manager.Manager, events, pushSecretEvents chan event.GenericEvent) (*controllers.Shard, error) {
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get the identity of the replica")
+		}
+		identity = hostname
+	}
+
+	namespace := opts.LeaderElectionNamespace
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get the namespace of the pod. Set --leader-election-namespace when running out of cluster")
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	return &controllers.Shard{
+		Client:           mgr.GetClient(),
+		APIReader:        mgr.GetAPIReader(),
+		Namespace:        namespace,
+		Group:            opts.ShardGroup,
+		Identity:         identity,
+		LeaseDuration:    opts.ShardLeaseDuration,
+		RenewInterval:    opts.ShardRenewInterval,
+		Events:           events,
+		PushSecretEvents: pushSecretEvents,
+		Log:              logf.Log.WithName("shard"),
+	}, nil
+}
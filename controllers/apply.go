@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	errs "github.com/pkg/errors"
+)
+
+// FieldManager is the name of the field manager the controller uses when server-side applying managed Secrets.
+// Only the fields set by this manager are owned by the operator, so that labels, annotations and data keys
+// added by other tools are left intact.
+const FieldManager = "aws-secret-operator"
+
+// applySecret server-side applies the desired Secret with the operator's field manager.
+//
+// The apiserver reports a conflict when another field manager owns one of the fields we set with a different value.
+// That usually happens once when migrating Secrets that were previously written with Update by an older version of
+// this operator or by another tool. The conflict is surfaced as an error unless ForceOwnership is enabled.
+func (r *AWSSecretController) applySecret(ctx context.Context, desired *corev1.Secret) error {
+	obj := secretForApply(desired)
+
+	if err := r.Client.Patch(ctx, obj, client.Apply, r.applyOptions()...); err != nil {
+		if errors.IsConflict(err) {
+			return errs.Wrapf(err, "conflicting field managers for secret %s/%s: "+
+				"remove the conflicting fields or run the operator with --force-ownership to take them over",
+				obj.Namespace, obj.Name)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *AWSSecretController) applyOptions() []client.PatchOption {
+	opts := []client.PatchOption{client.FieldOwner(FieldManager)}
+	if r.ForceOwnership {
+		opts = append(opts, client.ForceOwnership)
+	}
+	return opts
+}
+
+// secretForApply returns a copy of the secret suitable for server-side apply.
+// StringData is a write-only field that is never persisted, so it is folded into Data
+// to let the apiserver track our ownership of each key.
+func secretForApply(s *corev1.Secret) *corev1.Secret {
+	obj := s.DeepCopy()
+	obj.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+
+	if len(obj.StringData) > 0 {
+		if obj.Data == nil {
+			obj.Data = make(map[string][]byte, len(obj.StringData))
+		}
+		for k, v := range obj.StringData {
+			obj.Data[k] = []byte(v)
+		}
+		obj.StringData = nil
+	}
+
+	return obj
+}
+
+// changedMetadata returns the names of the metadata fields whose desired state is not yet reflected in the current Secret.
+// A field is considered changed when one of the desired entries is missing or different, or when the operator's field
+// manager still owns an entry that is no longer desired. Entries owned by other field managers are ignored.
+func changedMetadata(current, desired *corev1.Secret) []string {
+	var changed []string
+
+	if metadataChanged(current.Labels, desired.Labels, ownedMetadataKeys(current, "labels")) {
+		changed = append(changed, "labels")
+	}
+
+	if metadataChanged(current.Annotations, desired.Annotations, ownedMetadataKeys(current, "annotations")) {
+		changed = append(changed, "annotations")
+	}
+
+	return changed
+}
+
+func metadataChanged(current, desired map[string]string, owned []string) bool {
+	for k, v := range desired {
+		if cur, ok := current[k]; !ok || cur != v {
+			return true
+		}
+	}
+
+	for _, k := range owned {
+		if _, ok := desired[k]; !ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ownedMetadataKeys returns the keys of metadata.labels or metadata.annotations owned by the operator's field manager.
+func ownedMetadataKeys(obj metav1.Object, field string) []string {
+	var keys []string
+
+	for _, f := range obj.GetManagedFields() {
+		if f.Manager != FieldManager || f.Operation != metav1.ManagedFieldsOperationApply || f.FieldsV1 == nil {
+			continue
+		}
+
+		var fields struct {
+			Metadata map[string]map[string]json.RawMessage `json:"f:metadata"`
+		}
+
+		if err := json.Unmarshal(f.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+
+		for k := range fields.Metadata["f:"+field] {
+			if strings.HasPrefix(k, "f:") {
+				keys = append(keys, strings.TrimPrefix(k, "f:"))
+			}
+		}
+	}
+
+	return keys
+}
+
+// ownedDataKeys returns the keys of the Secret data owned by the operator's field manager.
+func ownedDataKeys(obj metav1.Object) []string {
+	var keys []string
+
+	for _, f := range obj.GetManagedFields() {
+		if f.Manager != FieldManager || f.Operation != metav1.ManagedFieldsOperationApply || f.FieldsV1 == nil {
+			continue
+		}
+
+		var fields struct {
+			Data map[string]json.RawMessage `json:"f:data"`
+		}
+
+		if err := json.Unmarshal(f.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+
+		for k := range fields.Data {
+			if strings.HasPrefix(k, "f:") {
+				keys = append(keys, strings.TrimPrefix(k, "f:"))
+			}
+		}
+	}
+
+	return keys
+}
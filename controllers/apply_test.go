@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestChangedMetadata(t *testing.T) {
+	managedFields := func(manager, raw string) []metav1.ManagedFieldsEntry {
+		return []metav1.ManagedFieldsEntry{
+			{
+				Manager:   manager,
+				Operation: metav1.ManagedFieldsOperationApply,
+				FieldsV1:  &metav1.FieldsV1{Raw: []byte(raw)},
+			},
+		}
+	}
+
+	type testcase struct {
+		name    string
+		current *corev1.Secret
+		desired *corev1.Secret
+		want    []string
+	}
+
+	testcases := []testcase{
+		{
+			name: "labels added by other tools are ignored",
+			current: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Labels:        map[string]string{"foo": "bar", "argocd.argoproj.io/instance": "app"},
+				ManagedFields: managedFields(FieldManager, `{"f:metadata":{"f:labels":{".":{},"f:foo":{}}}}`),
+			}},
+			desired: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"foo": "bar"},
+			}},
+		},
+		{
+			name: "changed label value",
+			current: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"foo": "bar"},
+			}},
+			desired: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"foo": "baz"},
+			}},
+			want: []string{"labels"},
+		},
+		{
+			name: "annotation owned by us is no longer desired",
+			current: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Annotations:   map[string]string{"foo": "bar"},
+				ManagedFields: managedFields(FieldManager, `{"f:metadata":{"f:annotations":{".":{},"f:foo":{}}}}`),
+			}},
+			desired: &corev1.Secret{},
+			want:    []string{"annotations"},
+		},
+		{
+			name: "annotation owned by another manager is not desired",
+			current: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Annotations:   map[string]string{"foo": "bar"},
+				ManagedFields: managedFields("kubectl", `{"f:metadata":{"f:annotations":{".":{},"f:foo":{}}}}`),
+			}},
+			desired: &corev1.Secret{},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := changedMetadata(tc.current, tc.desired)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected result:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSecretForApply(t *testing.T) {
+	desired := &corev1.Secret{
+		Data:       map[string][]byte{"foo": []byte("FOO")},
+		StringData: map[string]string{"bar": "BAR"},
+	}
+
+	got := secretForApply(desired)
+
+	if diff := cmp.Diff(map[string][]byte{"foo": []byte("FOO"), "bar": []byte("BAR")}, got.Data); diff != "" {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+	if got.StringData != nil {
+		t.Errorf("unexpected stringData: %v", got.StringData)
+	}
+	if got.APIVersion != "v1" || got.Kind != "Secret" {
+		t.Errorf("unexpected type meta: %v", got.TypeMeta)
+	}
+	if desired.StringData == nil {
+		t.Errorf("desired secret must not be modified")
+	}
+}
@@ -12,11 +12,16 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	errs "github.com/pkg/errors"
 )
@@ -28,11 +33,50 @@ func (r *AWSSecretController) SetupWithManager(mgr ctrl.Manager) error {
 		name = r.Name
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&mumoshuv1alpha1.AWSSecret{}).
-		Owns(&corev1.Secret{}).
-		Named(name).
-		Complete(r)
+	// Constructed before any reconciliation, because reconciliations run concurrently
+	if r.SyncContext == nil {
+		r.SyncContext = NewSyncContext(nil, nil)
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), &mumoshuv1alpha1.AWSSecret{}, awsSecretSecretIdIndex, func(o client.Object) []string {
+		return referencedSecretIds(o.(*mumoshuv1alpha1.AWSSecret))
+	}); err != nil {
+		return err
+	}
+
+	r.queue = r.PriorityQueue
+	if r.queue == nil {
+		r.queue = NewPriorityQueue(r.MaxConcurrentReconciles)
+	}
+
+	// Built without the builder, which enqueues all the events of AWSSecrets with the same priority
+	c, err := controller.New(name, mgr, controller.Options{Reconciler: r, MaxConcurrentReconciles: r.MaxConcurrentReconciles})
+	if err != nil {
+		return err
+	}
+
+	if err := c.Watch(&source.Kind{Type: &mumoshuv1alpha1.AWSSecret{}}, &priorityHandler{queue: r.queue}); err != nil {
+		return err
+	}
+
+	if err := c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{OwnerType: &mumoshuv1alpha1.AWSSecret{}, IsController: true}); err != nil {
+		return err
+	}
+
+	if r.Events != nil {
+		if err := c.Watch(&source.Channel{Source: r.Events}, &priorityHandler{queue: r.queue}); err != nil {
+			return err
+		}
+	}
+
+	if r.Quotas != nil {
+		if err := c.Watch(&source.Kind{Type: &mumoshuv1alpha1.AWSSecret{}}, &rankingInvalidator{quotas: r.Quotas}); err != nil {
+			return err
+		}
+	}
+
+	// Releases the periodic resyncs into the work queue
+	return c.Watch(r.queue, &handler.Funcs{})
 }
 
 var _ reconcile.Reconciler = &AWSSecretController{}
@@ -46,16 +90,97 @@ type AWSSecretController struct {
 	Client client.Client
 	Scheme *runtime.Scheme
 
+	// APIReader reads Secrets missing from the cache, which holds only the Secrets labelled as managed by the operator.
+	// Client is used instead when nil.
+	APIReader client.Reader
+
 	SyncContext *SyncContext
 	Log         *logr.Logger
+
+	// Recorder records Events on AWSSecrets. Events are not recorded when nil.
+	Recorder record.EventRecorder
+
+	// Events enqueues AWSSecrets whose Secrets Manager secrets have changed, ahead of the periodic resync
+	Events chan event.GenericEvent
+
+	// RefreshInterval is the interval of the periodic resync. Defaults to 5 minutes.
+	RefreshInterval time.Duration
+
+	// StalenessCheckInterval is the interval at which pinned versions are checked for staleness, in addition to spec changes.
+	// Defaults to 1 hour.
+	StalenessCheckInterval time.Duration
+
+	// DriftCheckInterval is the interval at which provisioned Secrets Manager secrets are checked for drift, in addition to spec changes.
+	// Defaults to 1 hour.
+	DriftCheckInterval time.Duration
+
+	// Shard makes the controller reconcile only the AWSSecrets assigned to this replica in the sharded mode.
+	// All AWSSecrets are reconciled when nil.
+	Shard *Shard
+
+	// Paused makes the controller skip writing Secrets and Secrets Manager secrets for all AWSSecrets,
+	// as if they were annotated with the paused annotation
+	Paused bool
+
+	// MaxConcurrentReconciles is the maximum number of AWSSecrets reconciled concurrently. Defaults to 1.
+	MaxConcurrentReconciles int
+
+	// FallbackRegions are the regions the secret values are read from in order when the primary region is unavailable,
+	// for the AWSSecrets without spec.fallbackRegions
+	FallbackRegions []string
+
+	// Quotas limits the number of AWSSecrets synced and the Secrets Manager API calls made per namespace.
+	// Unlimited when nil.
+	Quotas *Quotas
+
+	// ForceOwnership makes the controller take over fields of managed Secrets owned by other field managers
+	// on server-side apply conflicts, instead of failing the reconciliation.
+	ForceOwnership bool
+
+	// PriorityQueue holds the periodic resyncs back behind the AWSSecrets that need syncing.
+	// Pass the same one to the controllers restarted in-process to keep the scheduled resyncs. A new one is created when nil.
+	PriorityQueue *PriorityQueue
+
+	// queue is the PriorityQueue the controller was set up with. Resyncs are requeued as is when nil.
+	queue *PriorityQueue
 }
 
-// Reconcile reads that state of the cluster for a AWSSecret object and makes changes based on the state read
+// Reconcile reconciles the AWSSecret, and schedules its next periodic resync with the low priority
+func (r *AWSSecretController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	if r.Quotas != nil {
+		if budget := r.Quotas.budget(request.Namespace); budget != nil {
+			// The Secrets Manager API calls made by the reconciliation are charged to the namespace
+			scoped := *r
+			scoped.SyncContext = r.SyncContext.chargingTo(budget)
+			r = &scoped
+		}
+	}
+
+	if r.queue == nil {
+		return r.reconcileAWSSecret(ctx, request)
+	}
+
+	r.queue.started(request)
+
+	// Keeps the work queue fed with due resyncs at the pace reconciliations finish, rather than once per release interval
+	defer r.queue.release(time.Now())
+
+	result, err := r.reconcileAWSSecret(ctx, request)
+	if err != nil || result.Requeue || result.RequeueAfter <= 0 {
+		return result, err
+	}
+
+	r.queue.resyncAfter(request, result.RequeueAfter)
+
+	return reconcile.Result{}, nil
+}
+
+// reconcileAWSSecret reads that state of the cluster for a AWSSecret object and makes changes based on the state read
 // and what is in the AWSSecret.Spec
 // Note:
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will .
-func (r *AWSSecretController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+func (r *AWSSecretController) reconcileAWSSecret(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	var log logr.Logger
 	if r.Log != nil {
 		log = *r.Log
@@ -65,13 +190,19 @@ func (r *AWSSecretController) Reconcile(ctx context.Context, request reconcile.R
 
 	reqLogger := log.WithName("controller_awssecret").WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
 
+	if r.Shard != nil && !r.Shard.Owns(request.NamespacedName) {
+		// Reconciled by the replica owning it. Enqueued again if this replica owns it after rebalancing
+		reqLogger.V(1).Info("Skipping the awssecret owned by another replica")
+		return reconcile.Result{}, nil
+	}
+
 	// Fetch the AWSSecret instance
 	instance := &mumoshuv1alpha1.AWSSecret{}
 	err := r.Client.Get(ctx, request.NamespacedName, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
-			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Owned objects are automatically garbage collected, or retained by the finalizer according to the deletion policy.
 			// Return and don't requeue
 			return reconcile.Result{}, nil
 		}
@@ -79,8 +210,110 @@ func (r *AWSSecretController) Reconcile(ctx context.Context, request reconcile.R
 		return reconcile.Result{}, err
 	}
 
+	if !instance.DeletionTimestamp.IsZero() {
+		if err := r.finalize(ctx, reqLogger, instance); err != nil {
+			return reconcile.Result{}, errs.Wrap(err, "failed to finalize awssecret")
+		}
+		return reconcile.Result{}, nil
+	}
+
+	paused, err := r.reconcilePause(ctx, reqLogger, instance)
+	if err != nil {
+		return reconcile.Result{}, errs.Wrap(err, "failed to update paused condition")
+	}
+	if paused {
+		// Resumed by the annotation change, or by restarting the operator without --paused
+		return reconcile.Result{}, nil
+	}
+
+	retryAfter, err := r.reconcileQuota(ctx, reqLogger, instance)
+	if err != nil {
+		return reconcile.Result{}, errs.Wrap(err, "failed to check quota")
+	}
+	if retryAfter > 0 {
+		return reconcile.Result{RequeueAfter: retryAfter}, nil
+	}
+
+	if err := r.ensureFinalizer(ctx, instance); err != nil {
+		return reconcile.Result{}, errs.Wrap(err, "failed to add finalizer")
+	}
+
+	if err := r.reconcileGenerate(ctx, reqLogger, instance); err != nil {
+		return reconcile.Result{}, errs.Wrap(err, "failed to generate secrets manager secret")
+	}
+
+	if instance.Spec.Provision != nil {
+		if err := r.reconcileProvision(ctx, reqLogger, instance); err != nil {
+			return reconcile.Result{}, errs.Wrap(err, "failed to provision secrets manager secret")
+		}
+	}
+
+	rotating, err := r.reconcileRotation(ctx, reqLogger, instance)
+	if err != nil {
+		return reconcile.Result{}, errs.Wrap(err, "failed to rotate secrets manager secret")
+	}
+
+	if err := r.reconcileVersionStaleness(ctx, reqLogger, instance); err != nil {
+		// Not fatal. The pinned version is still synced as long as it exists
+		reqLogger.Info("Failed to check the staleness of the pinned versions", "error", err.Error())
+	}
+
+	forceSync := forceSyncRequest(instance)
+	if forceSync != "" {
+		reqLogger.Info("Force-syncing the Secret", "forceSync", forceSync)
+	}
+
+	result, err := r.syncSecret(ctx, reqLogger, instance, forceSync != "")
+	if err == nil {
+		if err := r.reconcileStageSecrets(ctx, reqLogger, instance); err != nil {
+			return reconcile.Result{}, errs.Wrap(err, "failed to write secrets of additional versions")
+		}
+	}
+
+	if err == nil && forceSync != "" {
+		if err := r.updateStatus(ctx, instance, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+			st.LastForceSync = forceSync
+		}); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if err == nil && rotating && result.RequeueAfter > rotationPollInterval {
+		// Check the progress of the rotation sooner than the periodic resync
+		result.RequeueAfter = rotationPollInterval
+	}
+
+	return result, err
+}
+
+// syncSecret writes the Secret built from the Secrets Manager secrets referenced by the AWSSecret.
+// When force is true, the secret values are refetched and the Secret is rewritten even when the source versions haven't changed.
+func (r *AWSSecretController) syncSecret(ctx context.Context, reqLogger logr.Logger, instance *mumoshuv1alpha1.AWSSecret, force bool) (reconcile.Result, error) {
+	// The live Secret lets us reuse the synced data when the source versions haven't changed
+	liveName := instance.Name
+	if instance.Spec.Versioned != nil {
+		liveName = instance.Status.CurrentSecretName
+	}
+
+	var live *corev1.Secret
+	if liveName != "" {
+		live = &corev1.Secret{}
+		if err := r.getSecret(ctx, types.NamespacedName{Name: liveName, Namespace: instance.Namespace}, live); err != nil {
+			if !errors.IsNotFound(err) {
+				return reconcile.Result{}, err
+			}
+			live = nil
+		}
+	}
+
+	// The spec may have changed how the data is built from the secret values
+	reusable := live
+	if force || instance.Status.SyncedGeneration != instance.Generation {
+		reusable = nil
+	}
+
 	// Define a new Secret object
-	desired, err := r.newSecretForCR(reqLogger, instance)
+	desired, servedRegion, err := r.newSecretForCR(reqLogger, instance, reusable)
 	if err != nil {
 		return reconcile.Result{}, errs.Wrap(err, "failed to compute secret for cr")
 	}
@@ -90,82 +323,193 @@ func (r *AWSSecretController) Reconcile(ctx context.Context, request reconcile.R
 		return reconcile.Result{}, err
 	}
 
-	// Check if this Secret already exists
-	current := &corev1.Secret{}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, current)
-	if err != nil && errors.IsNotFound(err) {
+	if instance.Spec.Versioned != nil {
+		return r.reconcileVersioned(ctx, reqLogger, instance, desired, servedRegion)
+	}
+
+	// The Secret named after the AWSSecret, or nil when it doesn't exist yet
+	current := live
+
+	decision := decideCreation(instance, desired.Name, current)
+	if !decision.Write {
+		if decision.Status != metav1.ConditionTrue {
+			reqLogger.Info("Not writing the Secret", "reason", decision.Reason, "message", decision.Message)
+		}
+		if err := r.updateStatus(ctx, instance, setReadyCondition(instance, decision.Status, decision.Reason, decision.Message)); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: r.requeueAfter(instance)}, nil
+	}
+
+	if decision.Merge {
+		// Merge only our keys into the existing Secret, leaving its ownership and type as is
+		desired.OwnerReferences = nil
+		desired.Type = ""
+	}
+
+	restartedHash := instance.Status.RestartedHash
+
+	updateStatus := func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.CurrentSecretName = desired.Name
+		st.RestartedHash = restartedHash
+		st.SyncedGeneration = instance.Generation
+		if servedRegion != "" {
+			st.ServedRegion = servedRegion
+		}
+		setReadyCondition(instance, decision.Status, decision.Reason, decision.Message)(st)
+	}
+
+	if current == nil {
 		reqLogger.Info("Secret does not exist, Creating a new Secret", "desired.Namespace", desired.Namespace, "desired.Name", desired.Name)
-		err = r.Client.Create(ctx, desired)
+		err = r.applySecret(ctx, desired)
 		if err != nil {
 			return reconcile.Result{}, err
 		}
 
-		// Secret created successfully - requeue after 5 minutes
-		reqLogger.Info("Secret Created successfully, RequeueAfter 5 minutes")
-		return reconcile.Result{RequeueAfter: time.Second * 300}, nil
-	} else if err != nil {
-		return reconcile.Result{}, err
+		// No workload has consumed the Secret yet
+		if instance.Spec.RestartWorkloads {
+			restartedHash = secretDataHash(desired)
+		}
+
+		if err := r.updateStatus(ctx, instance, updateStatus); err != nil {
+			return reconcile.Result{}, err
+		}
+
+		// Secret created successfully - requeue after the refresh interval
+		requeueAfter := r.requeueAfter(instance)
+		reqLogger.Info("Secret Created successfully", "RequeueAfter", requeueAfter)
+		return reconcile.Result{RequeueAfter: requeueAfter}, nil
 	}
 
 	var changed []string
 
-	if string(current.Data["AWSVersionId"]) != desired.StringData["AWSVersionId"] {
+	if versionIdsChanged(current, desired) {
 		changed = append(changed, "versionId")
 	}
 
-	if !reflect.DeepEqual(current.Labels, desired.Labels) {
-		changed = append(changed, "labels")
+	changed = append(changed, changedMetadata(current, desired)...)
+
+	if decision.Reason == mumoshuv1alpha1.ReasonAdopted {
+		changed = append(changed, "ownerReferences")
+	}
+
+	if dataModified(current) {
+		changed = append(changed, "data")
 	}
 
-	if !reflect.DeepEqual(current.Annotations, desired.Annotations) {
-		changed = append(changed, "annotations")
+	if force {
+		changed = append(changed, "forceSync")
 	}
 
-	// if Secret exists, only update if versionId has changed
+	// if Secret exists, only update if versionId or our own labels and annotations have changed
 	if len(changed) > 0 {
 		reqLogger.Info("Detected changes. Updating the Secret", "changed", changed, "desired.Namespace", desired.Namespace, "desired.Name", desired.Name)
-		err = r.Client.Update(ctx, desired)
+		err = r.applySecret(ctx, desired)
 		if err != nil {
 			return reconcile.Result{}, err
 		}
 
-		// Secret updated successfully - requeue after 5 minutes
-		reqLogger.Info("Secret Updated successfully, RequeueAfter 5 minutes")
-		return reconcile.Result{RequeueAfter: time.Second * 300}, nil
+		restartedHash, err = r.reconcileRestarts(ctx, reqLogger, instance, desired, contains(changed, "versionId"))
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		if err := r.updateStatus(ctx, instance, updateStatus); err != nil {
+			return reconcile.Result{}, err
+		}
+
+		// Secret updated successfully - requeue after the refresh interval
+		requeueAfter := r.requeueAfter(instance)
+		reqLogger.Info("Secret Updated successfully", "RequeueAfter", requeueAfter)
+		return reconcile.Result{RequeueAfter: requeueAfter}, nil
 	}
-	return reconcile.Result{RequeueAfter: time.Second * 300}, nil
+
+	// Retries the restarts that failed after the Secret was updated
+	restartedHash, err = r.reconcileRestarts(ctx, reqLogger, instance, desired, false)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.updateStatus(ctx, instance, updateStatus); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{RequeueAfter: r.requeueAfter(instance)}, nil
 }
 
-// newSecretForCR returns a Secret with the name/namespace defined in the cr
-func (r *AWSSecretController) newSecretForCR(reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) (*corev1.Secret, error) {
-	if r.SyncContext == nil {
-		r.SyncContext = newContext(nil)
+// updateStatus applies mutate to the status of the AWSSecret and patches it only when it has changed
+func (r *AWSSecretController) updateStatus(ctx context.Context, cr *mumoshuv1alpha1.AWSSecret, mutate func(*mumoshuv1alpha1.AWSSecretStatus)) error {
+	orig := cr.DeepCopy()
+
+	mutate(&cr.Status)
+
+	if reflect.DeepEqual(orig.Status, cr.Status) {
+		return nil
+	}
+
+	return r.Client.Status().Patch(ctx, cr, client.MergeFrom(orig))
+}
+
+// newSecretForCR returns a Secret with the name/namespace defined in the cr.
+// The data synced into the live Secret is reused without reading the secret values when it was synced from the same source versions.
+// It also returns the region that served the secret values, preferring a fallback region when the sources were served by several,
+// or an empty string when the values were not read.
+func (r *AWSSecretController) newSecretForCR(reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret, live *corev1.Secret) (*corev1.Secret, string, error) {
+	versions, err := r.resolveSourceVersions(cr)
+	if err != nil {
+		return nil, "", errs.Wrap(err, "failed to resolve source versions")
 	}
 
-	var err error
+	sourceVersions := formatSourceVersions(versions)
+
+	var servedRegion string
+
 	stringData := make(map[string]string)
-	if cr.Spec.StringDataFrom.SecretsManagerSecretRef.SecretId != "" &&
-		cr.Spec.StringDataFrom.SecretsManagerSecretRef.VersionId != "" {
-		ref := cr.Spec.StringDataFrom.SecretsManagerSecretRef
-		stringData, err = r.SyncContext.SecretsManagerSecretToKubernetesStringData(ref)
-		if err != nil {
-			return nil, errs.Wrap(err, "failed to get json secret as map")
+	data := reusableData(live, sourceVersions)
+
+	if data != nil {
+		reqLogger.V(1).Info("Source versions are unchanged. Reusing the synced data", "sourceVersions", sourceVersions)
+	} else {
+		data = make(map[string][]byte)
+
+		fallbackRegions := r.fallbackRegions(cr)
+
+		for _, s := range activeSources(cr) {
+			ref := s.ref
+			ref.VersionId = versions[s.name]
+
+			var region string
+			if s.name == "stringDataFrom" {
+				stringData, region, err = r.SyncContext.SecretsManagerSecretToKubernetesStringData(ref, fallbackRegions)
+			} else {
+				data, region, err = r.SyncContext.SecretsManagerSecretToKubernetesData(ref, fallbackRegions)
+			}
+			if err != nil {
+				return nil, "", errs.Wrap(err, "failed to get json secret as map")
+			}
+
+			if servedRegion == "" || r.SyncContext.isFallbackRegion(region) {
+				servedRegion = region
+			}
 		}
-	}
 
-	data := make(map[string][]byte)
-	if cr.Spec.DataFrom.SecretsManagerSecretRef.SecretId != "" &&
-		cr.Spec.DataFrom.SecretsManagerSecretRef.VersionId != "" {
-		ref := cr.Spec.DataFrom.SecretsManagerSecretRef
-		data, err = r.SyncContext.SecretsManagerSecretToKubernetesData(ref)
-		if err != nil {
-			return nil, errs.Wrap(err, "failed to get json secret as map")
+		if err := r.addStageKeys(cr, stringData, data); err != nil {
+			return nil, "", err
 		}
 	}
 
-	var labels, annotations map[string]string
+	labels := map[string]string{LabelManagedBy: managedByAWSSecret}
+	annotations := map[string]string{}
 	if m := cr.Spec.Metadata; m != nil {
-		labels, annotations = m.Labels, m.Annotations
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		for k, v := range m.Annotations {
+			annotations[k] = v
+		}
+	}
+	if sourceVersions != "" {
+		annotations[AnnotationSourceVersions] = sourceVersions
 	}
 
 	secret := &corev1.Secret{
@@ -181,9 +525,11 @@ func (r *AWSSecretController) newSecretForCR(reqLogger logr.Logger, cr *mumoshuv
 		Type:       cr.Spec.Type,
 	}
 
+	annotations[AnnotationDataHash] = secretDataHash(secret)
+
 	if reqLogger.V(2).Enabled() {
 		reqLogger.V(2).Info("Dumping the desired secret", "meta", secret.ObjectMeta, "stringData", secret.StringData)
 	}
 
-	return secret, nil
+	return secret, servedRegion, nil
 }
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ManagedSecretsOnly wraps the cache constructor to make the cache hold only the Secrets labelled as managed by the operator,
+// so that the memory usage of the operator scales with the number of managed Secrets and unrelated secret data is never held.
+func ManagedSecretsOnly(newCache cache.NewCacheFunc) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = cache.SelectorsByObject{
+			&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{LabelManagedBy: managedByAWSSecret})},
+		}
+
+		return newCache(config, opts)
+	}
+}
+
+// getSecret gets the Secret from the cache, or from the apiserver when it is missing from the cache.
+// Foreign Secrets and the ones written by older versions of the operator aren't labelled as managed, and therefore only found in the latter.
+// Every miss costs a request to the apiserver, so it is meant for Secrets that may legitimately be unlabelled, like the ones to adopt.
+func (r *AWSSecretController) getSecret(ctx context.Context, key types.NamespacedName, s *corev1.Secret) error {
+	err := r.Client.Get(ctx, key, s)
+	if !errors.IsNotFound(err) || r.APIReader == nil {
+		return err
+	}
+
+	return r.APIReader.Get(ctx, key, s)
+}
+
+// apiReader returns the reader of the objects the cache doesn't hold, like Secrets that aren't labelled as managed,
+// workloads, Pods and pointer ConfigMaps. They are read from the apiserver, so that the Pods just created with a previous Secret generation
+// are never missed, and the cache doesn't hold every Secret, Pod and ConfigMap in the watched namespaces.
+func (r *AWSSecretController) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+var _ manager.Runnable = &SecretMetadataInformer{}
+
+// SecretMetadataInformer watches the metadata of the Secrets in a namespace regardless of their labels, for PushSecrets.
+// The annotations and managedFields are stripped before the Secrets are stored, because annotations like
+// `kubectl.kubernetes.io/last-applied-configuration` hold the data of the Secrets, which the operator must never hold in memory.
+type SecretMetadataInformer struct {
+	toolscache.SharedIndexInformer
+}
+
+// NewSecretMetadataInformer returns the informer of the Secrets in the namespace, or in all the namespaces when empty
+func NewSecretMetadataInformer(c metadata.Interface, namespace string) *SecretMetadataInformer {
+	secrets := c.Resource(corev1.SchemeGroupVersion.WithResource("secrets")).Namespace(namespace)
+
+	lw := &toolscache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			list, err := secrets.List(context.TODO(), opts)
+			if err != nil {
+				return nil, err
+			}
+			for i := range list.Items {
+				stripSecretMetadata(&list.Items[i])
+			}
+			return list, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			w, err := secrets.Watch(context.TODO(), opts)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+				if m, ok := e.Object.(*metav1.PartialObjectMetadata); ok {
+					stripSecretMetadata(m)
+				}
+				return e, true
+			}), nil
+		},
+	}
+
+	return &SecretMetadataInformer{
+		SharedIndexInformer: toolscache.NewSharedIndexInformer(lw, &metav1.PartialObjectMetadata{}, 0, toolscache.Indexers{}),
+	}
+}
+
+// Start runs the informer until the context is done
+func (i *SecretMetadataInformer) Start(ctx context.Context) error {
+	i.Run(ctx.Done())
+	return nil
+}
+
+// stripSecretMetadata drops the metadata of the Secret that may hold its data
+func stripSecretMetadata(m *metav1.PartialObjectMetadata) {
+	m.Annotations = nil
+	m.ManagedFields = nil
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetSecretFallsBackToAPIReader(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	unlabelled := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "default"}}
+
+	r := &AWSSecretController{
+		// The cache doesn't hold Secrets without the managed-by label
+		Client:    fake.NewClientBuilder().WithScheme(scheme).Build(),
+		APIReader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(unlabelled).Build(),
+	}
+
+	var got corev1.Secret
+	if err := r.getSecret(ctx, types.NamespacedName{Namespace: "default", Name: "foreign"}, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Name != "foreign" {
+		t.Errorf("want foreign, got %q", got.Name)
+	}
+
+	if err := r.getSecret(ctx, types.NamespacedName{Namespace: "default", Name: "missing"}, &got); !errors.IsNotFound(err) {
+		t.Errorf("want not found, got %v", err)
+	}
+}
+
+// countingReader counts the reads made directly against the apiserver
+type countingReader struct {
+	client.Reader
+	gets int
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	r.gets++
+	return r.Reader.Get(ctx, key, obj)
+}
+
+func TestStageSecretsReadFromCache(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"}}
+
+	apiReader := &countingReader{Reader: fake.NewClientBuilder().WithScheme(scheme).Build()}
+
+	r := &AWSSecretController{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build(),
+		Scheme:    scheme,
+		APIReader: apiReader,
+	}
+
+	// The Secrets of the previous and pending versions that don't exist and aren't desired are looked up only in the cache
+	if err := r.reconcileStageSecrets(ctx, logr.Discard(), cr); err != nil {
+		t.Fatal(err)
+	}
+
+	if apiReader.gets != 0 {
+		t.Errorf("want no reads from the apiserver, got %d", apiReader.gets)
+	}
+}
+
+func TestSecretMetadataInformerStripsAnnotations(t *testing.T) {
+	secret := &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pushed",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "myapp"},
+			Annotations: map[string]string{
+				"kubectl.kubernetes.io/last-applied-configuration": `{"data":{"password":"c2VjcmV0"}}`,
+			},
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+		},
+	}
+
+	i := NewSecretMetadataInformer(metadatafake.NewSimpleMetadataClient(newMetadataScheme(t), secret), "default")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = i.Start(ctx)
+	}()
+
+	if !toolscache.WaitForCacheSync(ctx.Done(), i.HasSynced) {
+		t.Fatal("failed to sync the informer")
+	}
+
+	obj, exists, err := i.GetStore().GetByKey("default/pushed")
+	if err != nil || !exists {
+		t.Fatalf("want the secret in the store, got %v, %v", exists, err)
+	}
+
+	got := obj.(*metav1.PartialObjectMetadata)
+	if len(got.Annotations) != 0 || len(got.ManagedFields) != 0 {
+		t.Errorf("want the annotations and managed fields stripped, got %v, %v", got.Annotations, got.ManagedFields)
+	}
+	if got.Labels["app"] != "myapp" {
+		t.Errorf("want the labels kept, got %v", got.Labels)
+	}
+}
+
+func newMetadataScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+
+	s := runtime.NewScheme()
+	if err := metav1.AddMetaToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	s.AddKnownTypeWithName(corev1.SchemeGroupVersion.WithKind("Secret"), &metav1.PartialObjectMetadata{})
+	s.AddKnownTypeWithName(corev1.SchemeGroupVersion.WithKind("SecretList"), &metav1.PartialObjectMetadataList{})
+	return s
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"fmt"
+
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// creationDecision is the outcome of applying the creation policy of an AWSSecret to the target Secret
+type creationDecision struct {
+	// Write is true when the controller is allowed to write the Secret
+	Write bool
+	// Merge is true when the Secret must be written without taking ownership of it
+	Merge bool
+
+	Status  metav1.ConditionStatus
+	Reason  string
+	Message string
+}
+
+// decideCreation applies the creation policy of the AWSSecret to the target Secret.
+// current is nil when the Secret does not exist yet.
+func decideCreation(cr *mumoshuv1alpha1.AWSSecret, name string, current *corev1.Secret) creationDecision {
+	owned := current != nil && metav1.IsControlledBy(current, cr)
+
+	switch cr.Spec.CreationPolicy {
+	case mumoshuv1alpha1.CreationPolicyNone:
+		return creationDecision{
+			Status:  metav1.ConditionTrue,
+			Reason:  mumoshuv1alpha1.ReasonValidated,
+			Message: fmt.Sprintf("Fetched the source data without writing Secret %s", name),
+		}
+	case mumoshuv1alpha1.CreationPolicyMerge:
+		if current == nil {
+			return creationDecision{
+				Status:  metav1.ConditionFalse,
+				Reason:  mumoshuv1alpha1.ReasonSecretNotFound,
+				Message: fmt.Sprintf("Secret %s does not exist to merge the source data into", name),
+			}
+		}
+		return creationDecision{
+			Write:   true,
+			Merge:   true,
+			Status:  metav1.ConditionTrue,
+			Reason:  mumoshuv1alpha1.ReasonMerged,
+			Message: fmt.Sprintf("Merged the source data into Secret %s", name),
+		}
+	case mumoshuv1alpha1.CreationPolicyAdopt:
+		if current != nil && !owned {
+			if owner := metav1.GetControllerOf(current); owner != nil {
+				return creationDecision{
+					Status:  metav1.ConditionFalse,
+					Reason:  mumoshuv1alpha1.ReasonForeignSecretExists,
+					Message: fmt.Sprintf("Secret %s is controlled by %s %s", name, owner.Kind, owner.Name),
+				}
+			}
+			return creationDecision{
+				Write:   true,
+				Status:  metav1.ConditionTrue,
+				Reason:  mumoshuv1alpha1.ReasonAdopted,
+				Message: fmt.Sprintf("Adopted Secret %s", name),
+			}
+		}
+	default:
+		if current != nil && !owned {
+			return creationDecision{
+				Status: metav1.ConditionFalse,
+				Reason: mumoshuv1alpha1.ReasonForeignSecretExists,
+				Message: fmt.Sprintf("Secret %s already exists and is not owned by this AWSSecret. "+
+					"Set creationPolicy to Adopt or Merge to manage it", name),
+			}
+		}
+	}
+
+	return creationDecision{
+		Write:   true,
+		Status:  metav1.ConditionTrue,
+		Reason:  mumoshuv1alpha1.ReasonOwned,
+		Message: fmt.Sprintf("Secret %s is in sync", name),
+	}
+}
+
+// setReadyCondition returns a status mutation that sets the Ready condition
+func setReadyCondition(cr *mumoshuv1alpha1.AWSSecret, status metav1.ConditionStatus, reason, message string) func(*mumoshuv1alpha1.AWSSecretStatus) {
+	return func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionReady, status, reason, message)
+	}
+}
+
+func setCondition(cr *mumoshuv1alpha1.AWSSecret, conditions *[]metav1.Condition, typ string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               typ,
+		Status:             status,
+		ObservedGeneration: cr.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"testing"
+
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestDecideCreation(t *testing.T) {
+	cr := &mumoshuv1alpha1.AWSSecret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: mumoshuv1alpha1.GroupVersion.String(), Kind: "AWSSecret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "example", UID: "uid"},
+	}
+
+	owned := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+		{Kind: "AWSSecret", Name: "example", UID: "uid", Controller: pointer.Bool(true)},
+	}}}
+	foreign := &corev1.Secret{}
+	controlled := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+		{Kind: "SealedSecret", Name: "example", UID: "other", Controller: pointer.Bool(true)},
+	}}}
+
+	type testcase struct {
+		name       string
+		policy     mumoshuv1alpha1.CreationPolicy
+		current    *corev1.Secret
+		wantWrite  bool
+		wantMerge  bool
+		wantReason string
+	}
+
+	testcases := []testcase{
+		{name: "owner creates", current: nil, wantWrite: true, wantReason: mumoshuv1alpha1.ReasonOwned},
+		{name: "owner updates owned", current: owned, wantWrite: true, wantReason: mumoshuv1alpha1.ReasonOwned},
+		{name: "owner refuses foreign", current: foreign, wantReason: mumoshuv1alpha1.ReasonForeignSecretExists},
+		{name: "adopt foreign", policy: mumoshuv1alpha1.CreationPolicyAdopt, current: foreign, wantWrite: true, wantReason: mumoshuv1alpha1.ReasonAdopted},
+		{name: "adopt refuses controlled", policy: mumoshuv1alpha1.CreationPolicyAdopt, current: controlled, wantReason: mumoshuv1alpha1.ReasonForeignSecretExists},
+		{name: "merge into foreign", policy: mumoshuv1alpha1.CreationPolicyMerge, current: foreign, wantWrite: true, wantMerge: true, wantReason: mumoshuv1alpha1.ReasonMerged},
+		{name: "merge requires existing", policy: mumoshuv1alpha1.CreationPolicyMerge, current: nil, wantReason: mumoshuv1alpha1.ReasonSecretNotFound},
+		{name: "none never writes", policy: mumoshuv1alpha1.CreationPolicyNone, current: nil, wantReason: mumoshuv1alpha1.ReasonValidated},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cr := cr.DeepCopy()
+			cr.Spec.CreationPolicy = tc.policy
+
+			got := decideCreation(cr, "example", tc.current)
+
+			if got.Write != tc.wantWrite || got.Merge != tc.wantMerge || got.Reason != tc.wantReason {
+				t.Errorf("unexpected decision: want write=%v merge=%v reason=%s, got %+v", tc.wantWrite, tc.wantMerge, tc.wantReason, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// awsSecretSecretIdIndex is the field index of AWSSecrets by the IDs of the Secrets Manager secrets they reference,
+// either names or ARNs as written in their specs
+const awsSecretSecretIdIndex = "spec.secretsManagerSecretRef.secretId"
+
+// resyncEventNames are the names of the Secrets Manager events after which the AWSSecrets referencing the secret are resynced
+var resyncEventNames = map[string]bool{
+	"PutSecretValue":           true,
+	"UpdateSecret":             true,
+	"UpdateSecretVersionStage": true,
+	"RotationSucceeded":        true,
+}
+
+// secretARNSuffix matches the random suffix Secrets Manager appends to the name in secret ARNs
+var secretARNSuffix = regexp.MustCompile(`-[a-zA-Z0-9]{6}$`)
+
+// secretName returns the name of the Secrets Manager secret identified by the name or the complete ARN
+func secretName(secretId string) string {
+	if !strings.HasPrefix(secretId, "arn:") {
+		return secretId
+	}
+
+	i := strings.Index(secretId, ":secret:")
+	if i < 0 {
+		return secretId
+	}
+
+	return secretARNSuffix.ReplaceAllString(secretId[i+len(":secret:"):], "")
+}
+
+// eventSecretIds returns the secret IDs AWSSecrets can reference the secret identified in an event with.
+// Secrets Manager identifies secrets with complete ARNs in events, which AWSSecrets can reference as they are,
+// as partial ARNs without the random suffix, or by the names.
+func eventSecretIds(secretId string) []string {
+	if !strings.HasPrefix(secretId, "arn:") || !strings.Contains(secretId, ":secret:") {
+		return []string{secretId}
+	}
+
+	ids := []string{secretId}
+
+	if partial := secretARNSuffix.ReplaceAllString(secretId, ""); partial != secretId {
+		ids = append(ids, partial)
+	}
+
+	return append(ids, secretName(secretId))
+}
+
+// referencedSecretIds returns the IDs of the Secrets Manager secrets the AWSSecret references, for the field index.
+// ARNs are indexed as they are, so that they are never matched by names that happen to end with a suffix-like part.
+func referencedSecretIds(cr *mumoshuv1alpha1.AWSSecret) []string {
+	var ids []string
+
+	for _, id := range []string{cr.Spec.StringDataFrom.SecretsManagerSecretRef.SecretId, cr.Spec.DataFrom.SecretsManagerSecretRef.SecretId} {
+		if id == "" {
+			continue
+		}
+		if len(ids) == 0 || ids[0] != id {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// secretsManagerEvent is an EventBridge event for a Secrets Manager API call recorded by CloudTrail,
+// or a Secrets Manager service event like RotationSucceeded
+type secretsManagerEvent struct {
+	Source    string   `json:"source"`
+	Resources []string `json:"resources"`
+	Detail    struct {
+		EventName         string `json:"eventName"`
+		RequestParameters *struct {
+			SecretId string `json:"secretId"`
+		} `json:"requestParameters"`
+		ResponseElements *struct {
+			ARN string `json:"arn"`
+		} `json:"responseElements"`
+		AdditionalEventData *struct {
+			SecretId string `json:"SecretId"`
+		} `json:"additionalEventData"`
+	} `json:"detail"`
+}
+
+// secretIdsOfEvent returns the IDs of the Secrets Manager secrets changed by the EventBridge event.
+// It returns nothing for events that don't change secret values.
+func secretIdsOfEvent(body []byte) ([]string, error) {
+	var e secretsManagerEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, err
+	}
+
+	if e.Source != "aws.secretsmanager" || !resyncEventNames[e.Detail.EventName] {
+		return nil, nil
+	}
+
+	ids := append([]string{}, e.Resources...)
+
+	if p := e.Detail.RequestParameters; p != nil {
+		ids = append(ids, p.SecretId)
+	}
+
+	if r := e.Detail.ResponseElements; r != nil {
+		ids = append(ids, r.ARN)
+	}
+
+	if d := e.Detail.AdditionalEventData; d != nil {
+		ids = append(ids, d.SecretId)
+	}
+
+	var nonEmpty []string
+	for _, id := range ids {
+		if id != "" {
+			nonEmpty = append(nonEmpty, id)
+		}
+	}
+
+	return nonEmpty, nil
+}
+
+// Enqueuer enqueues the AWSSecrets referencing any of the changed Secrets Manager secrets,
+// and returns the number of the enqueued AWSSecrets
+type Enqueuer interface {
+	Enqueue(ctx context.Context, secretIds []string) (int, error)
+}
+
+var _ Enqueuer = &SecretEventEnqueuer{}
+
+// SecretEventEnqueuer enqueues the AWSSecrets referencing Secrets Manager secrets that changed,
+// by sending them to the channel watched by the AWSSecretController.
+//
+// AWSSecrets reconciled by other replicas, like the leader or their owners in the sharded mode, are forwarded to them instead,
+// by annotating them with the time of the request. The annotation change enqueues the AWSSecret with the high priority
+// on the replica reconciling it, so the event is not lost even though the event source has already been acknowledged.
+type SecretEventEnqueuer struct {
+	Client client.Client
+	Events chan<- event.GenericEvent
+
+	// Shard tells whether this replica owns the AWSSecret in the sharded mode. All AWSSecrets are enqueued locally when nil.
+	Shard *Shard
+
+	// Elected is closed once this replica is elected as the leader running the AWSSecretController.
+	// AWSSecrets are forwarded to the leader until then. All AWSSecrets are enqueued locally when nil.
+	Elected <-chan struct{}
+}
+
+// Enqueue enqueues the AWSSecrets referencing any of the secrets, and returns the number of the enqueued AWSSecrets
+func (e *SecretEventEnqueuer) Enqueue(ctx context.Context, secretIds []string) (int, error) {
+	seen := map[string]bool{}
+	enqueued := map[client.ObjectKey]bool{}
+
+	var ids []string
+	for _, id := range secretIds {
+		for _, i := range eventSecretIds(id) {
+			if !seen[i] {
+				seen[i] = true
+				ids = append(ids, i)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		var list mumoshuv1alpha1.AWSSecretList
+		if err := e.Client.List(ctx, &list, client.MatchingFields{awsSecretSecretIdIndex: id}); err != nil {
+			return len(enqueued), err
+		}
+
+		for i := range list.Items {
+			cr := &list.Items[i]
+			key := client.ObjectKeyFromObject(cr)
+			if enqueued[key] {
+				continue
+			}
+
+			if !e.reconcilesLocally(key) {
+				if err := e.forward(ctx, cr); err != nil {
+					return len(enqueued), err
+				}
+				enqueued[key] = true
+				continue
+			}
+
+			select {
+			case e.Events <- event.GenericEvent{Object: cr}:
+				enqueued[key] = true
+			case <-ctx.Done():
+				return len(enqueued), ctx.Err()
+			}
+		}
+	}
+
+	return len(enqueued), nil
+}
+
+// reconcilesLocally returns true when the AWSSecret is reconciled by the AWSSecretController of this replica
+func (e *SecretEventEnqueuer) reconcilesLocally(key client.ObjectKey) bool {
+	if e.Elected != nil {
+		select {
+		case <-e.Elected:
+		default:
+			return false
+		}
+	}
+
+	return e.Shard == nil || e.Shard.Owns(key)
+}
+
+// forward requests the resync of the AWSSecret from the replica reconciling it, by annotating it with the current time
+func (e *SecretEventEnqueuer) forward(ctx context.Context, cr *mumoshuv1alpha1.AWSSecret) error {
+	orig := cr.DeepCopy()
+
+	if cr.Annotations == nil {
+		cr.Annotations = map[string]string{}
+	}
+	cr.Annotations[AnnotationResyncRequestedAt] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	if err := e.Client.Patch(ctx, cr, client.MergeFrom(orig)); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
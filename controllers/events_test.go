@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestSecretName(t *testing.T) {
+	testcases := map[string]string{
+		"prod/mysecret": "prod/mysecret",
+		"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-Ld0PUs":  "prod/mysecret",
+		"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/my-secret-Ld0PUs": "prod/my-secret",
+	}
+
+	for id, want := range testcases {
+		if got := secretName(id); got != want {
+			t.Errorf("secretName(%s): want %s, got %s", id, want, got)
+		}
+	}
+}
+
+func TestEventSecretIds(t *testing.T) {
+	testcases := map[string][]string{
+		"prod/mysecret": {"prod/mysecret"},
+		"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-Ld0PUs": {
+			"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-Ld0PUs",
+			"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret",
+			"prod/mysecret",
+		},
+	}
+
+	for id, want := range testcases {
+		if diff := cmp.Diff(want, eventSecretIds(id)); diff != "" {
+			t.Errorf("eventSecretIds(%s): unexpected ids (-want +got):\n%s", id, diff)
+		}
+	}
+}
+
+func TestReferencedSecretIds(t *testing.T) {
+	// The ARN is indexed as is, instead of the name with the suffix-like "-secret" stripped
+	arn := "arn:aws:secretsmanager:us-east-1:123456789012:secret:my-app-secret"
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: arn},
+			},
+			DataFrom: mumoshuv1alpha1.DataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "my-app"},
+			},
+		},
+	}
+
+	if diff := cmp.Diff([]string{arn, "my-app"}, referencedSecretIds(cr)); diff != "" {
+		t.Errorf("unexpected ids (-want +got):\n%s", diff)
+	}
+
+	// An event of the secret my-app doesn't match the arn of my-app-secret
+	for _, id := range eventSecretIds("arn:aws:secretsmanager:us-east-1:123456789012:secret:my-app-AbCdEf") {
+		if id == arn {
+			t.Errorf("want the arn of my-app-secret not matched by the events of my-app")
+		}
+	}
+}
+
+func TestSecretIdsOfEvent(t *testing.T) {
+	type testcase struct {
+		name  string
+		event string
+		want  []string
+	}
+
+	testcases := []testcase{
+		{
+			name: "PutSecretValue",
+			event: `{"source":"aws.secretsmanager","detail-type":"AWS API Call via CloudTrail","resources":[],
+"detail":{"eventName":"PutSecretValue","requestParameters":{"secretId":"prod/mysecret"},
+"responseElements":{"arn":"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-Ld0PUs"}}}`,
+			want: []string{"prod/mysecret", "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-Ld0PUs"},
+		},
+		{
+			name: "RotationSucceeded",
+			event: `{"source":"aws.secretsmanager","detail-type":"AWS Service Event via CloudTrail",
+"detail":{"eventName":"RotationSucceeded","additionalEventData":{"SecretId":"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-Ld0PUs"}}}`,
+			want: []string{"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-Ld0PUs"},
+		},
+		{
+			name:  "GetSecretValue is ignored",
+			event: `{"source":"aws.secretsmanager","detail":{"eventName":"GetSecretValue","requestParameters":{"secretId":"prod/mysecret"}}}`,
+		},
+		{
+			name:  "other sources are ignored",
+			event: `{"source":"aws.ssm","detail":{"eventName":"PutSecretValue","requestParameters":{"secretId":"prod/mysecret"}}}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := secretIdsOfEvent([]byte(tc.event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected secret ids (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSecretEventEnqueuerForwarding(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := 0; i < 10; i++ {
+		builder = builder.WithObjects(&mumoshuv1alpha1.AWSSecret{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("example-%d", i), Namespace: "default"},
+			Spec: mumoshuv1alpha1.AWSSecretSpec{
+				StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+					SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret"},
+				},
+			},
+		})
+	}
+	c := builder.Build()
+
+	shard := &Shard{Identity: "replica-0", members: []string{"replica-0", "replica-1"}, renewed: time.Now()}
+	events := make(chan event.GenericEvent, 10)
+
+	e := &SecretEventEnqueuer{Client: c, Events: events, Shard: shard}
+
+	n, err := e.Enqueue(ctx, []string{"prod/mysecret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 10 {
+		t.Errorf("want all the 10 awssecrets enqueued or forwarded, got %d", n)
+	}
+
+	var list mumoshuv1alpha1.AWSSecretList
+	if err := c.List(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+
+	var local, forwarded int
+	for i := range list.Items {
+		cr := &list.Items[i]
+		_, annotated := cr.Annotations[AnnotationResyncRequestedAt]
+
+		if owned := shard.Owns(client.ObjectKeyFromObject(cr)); owned == annotated {
+			t.Errorf("%s: want forwarded only when owned by another replica, owned %v, forwarded %v", cr.Name, owned, annotated)
+		}
+
+		if annotated {
+			forwarded++
+		} else {
+			local++
+		}
+	}
+
+	if local != len(events) || forwarded == 0 {
+		t.Errorf("want the owned awssecrets enqueued locally and the rest forwarded, got %d enqueued, %d local, %d forwarded", len(events), local, forwarded)
+	}
+}
+
+func TestSecretEventEnqueuerForwardingToLeader(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret"},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build()
+
+	elected := make(chan struct{})
+	events := make(chan event.GenericEvent, 1)
+
+	e := &SecretEventEnqueuer{Client: c, Events: events, Elected: elected}
+
+	if n, err := e.Enqueue(ctx, []string{"prod/mysecret"}); err != nil || n != 1 {
+		t.Fatalf("want the awssecret forwarded, got %d, %v", n, err)
+	}
+
+	var got mumoshuv1alpha1.AWSSecret
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cr), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Annotations[AnnotationResyncRequestedAt]; !ok || len(events) != 0 {
+		t.Errorf("want the awssecret forwarded to the leader before this replica is elected, got %d enqueued, annotations %v", len(events), got.Annotations)
+	}
+
+	close(elected)
+
+	if n, err := e.Enqueue(ctx, []string{"prod/mysecret"}); err != nil || n != 1 || len(events) != 1 {
+		t.Errorf("want the awssecret enqueued locally once elected, got %d enqueued, %d, %v", len(events), n, err)
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ensureFinalizer adds the finalizer to the AWSSecret so that the controller gets a chance to
+// retain the managed Secrets before the AWSSecret is gone
+func (r *AWSSecretController) ensureFinalizer(ctx context.Context, cr *mumoshuv1alpha1.AWSSecret) error {
+	if controllerutil.ContainsFinalizer(cr, Finalizer) {
+		return nil
+	}
+
+	patch := client.MergeFromWithOptions(cr.DeepCopy(), client.MergeFromWithOptimisticLock{})
+
+	controllerutil.AddFinalizer(cr, Finalizer)
+
+	return r.Client.Patch(ctx, cr, patch)
+}
+
+// finalize handles the deletion of the AWSSecret according to its deletion policy, and removes the finalizer.
+func (r *AWSSecretController) finalize(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) error {
+	if !controllerutil.ContainsFinalizer(cr, Finalizer) {
+		return nil
+	}
+
+	deleteVersionMetrics(cr.Namespace, cr.Name, "stringDataFrom")
+	deleteVersionMetrics(cr.Namespace, cr.Name, "dataFrom")
+
+	if cr.Spec.DeletionPolicy == mumoshuv1alpha1.DeletionPolicyRetain {
+		if err := r.retainSecrets(ctx, reqLogger, cr); err != nil {
+			return err
+		}
+	}
+
+	patch := client.MergeFromWithOptions(cr.DeepCopy(), client.MergeFromWithOptimisticLock{})
+
+	controllerutil.RemoveFinalizer(cr, Finalizer)
+
+	return r.Client.Patch(ctx, cr, patch)
+}
+
+// retainSecrets strips the owner reference to the AWSSecret and the operator's labels from all the Secrets
+// controlled by the AWSSecret, and from the pointer ConfigMap of versioned Secrets,
+// so that they aren't garbage-collected along with it.
+func (r *AWSSecretController) retainSecrets(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) error {
+	var secrets corev1.SecretList
+	if err := r.apiReader().List(ctx, &secrets, client.InNamespace(cr.Namespace)); err != nil {
+		return err
+	}
+
+	for i := range secrets.Items {
+		s := &secrets.Items[i]
+
+		if !metav1.IsControlledBy(s, cr) {
+			continue
+		}
+
+		reqLogger.Info("Retaining Secret", "Secret.Namespace", s.Namespace, "Secret.Name", s.Name)
+
+		if err := r.retain(ctx, cr, s); err != nil {
+			return err
+		}
+	}
+
+	if cr.Spec.Versioned == nil || cr.Spec.Versioned.PointerConfigMapName == "" {
+		return nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.apiReader().Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: cr.Spec.Versioned.PointerConfigMapName}, cm); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !metav1.IsControlledBy(cm, cr) {
+		return nil
+	}
+
+	reqLogger.Info("Retaining pointer ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+
+	return r.retain(ctx, cr, cm)
+}
+
+// retain strips the owner reference to the AWSSecret and the operator's labels from the object
+func (r *AWSSecretController) retain(ctx context.Context, cr *mumoshuv1alpha1.AWSSecret, obj client.Object) error {
+	patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+
+	var refs []metav1.OwnerReference
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != cr.UID {
+			refs = append(refs, ref)
+		}
+	}
+	obj.SetOwnerReferences(refs)
+
+	labels := obj.GetLabels()
+	for _, l := range operatorLabels {
+		delete(labels, l)
+	}
+	obj.SetLabels(labels)
+
+	if err := r.Client.Patch(ctx, obj, patch); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
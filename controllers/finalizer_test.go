@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := mumoshuv1alpha1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFinalizeRetain(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "example",
+			Namespace:  "default",
+			UID:        "uid",
+			Finalizers: []string{Finalizer},
+		},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			DeletionPolicy: mumoshuv1alpha1.DeletionPolicyRetain,
+		},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "default",
+			Labels:    map[string]string{LabelAWSSecret: "example", "app": "myapp"},
+		},
+	}
+	if err := controllerutil.SetControllerReference(cr, secret, scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr, secret).Build()
+
+	r := &AWSSecretController{Client: c, Scheme: scheme}
+
+	if err := r.finalize(ctx, logr.Discard(), cr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.OwnerReferences) != 0 {
+		t.Errorf("unexpected owner references: %v", got.OwnerReferences)
+	}
+
+	if diff := cmp.Diff(map[string]string{"app": "myapp"}, got.Labels); diff != "" {
+		t.Errorf("unexpected labels:\n%s", diff)
+	}
+
+	var gotCR mumoshuv1alpha1.AWSSecret
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &gotCR); err != nil {
+		t.Fatal(err)
+	}
+
+	if controllerutil.ContainsFinalizer(&gotCR, Finalizer) {
+		t.Errorf("finalizer must be removed")
+	}
+}
+
+func TestFinalizeRetainPointerConfigMap(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "example",
+			Namespace:  "default",
+			UID:        "uid",
+			Finalizers: []string{Finalizer},
+		},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			DeletionPolicy: mumoshuv1alpha1.DeletionPolicyRetain,
+			Versioned:      &mumoshuv1alpha1.VersionedSecrets{PointerConfigMapName: "example-current"},
+		},
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-current", Namespace: "default"},
+		Data:       map[string]string{pointerConfigMapKey: "example-5d1f0c9a2b"},
+	}
+	if err := controllerutil.SetControllerReference(cr, cm, scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr, cm).Build()
+
+	r := &AWSSecretController{Client: c, Scheme: scheme}
+
+	if err := r.finalize(ctx, logr.Discard(), cr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got corev1.ConfigMap
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example-current"}, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.OwnerReferences) != 0 {
+		t.Errorf("unexpected owner references: %v", got.OwnerReferences)
+	}
+
+	if got.Data[pointerConfigMapKey] != "example-5d1f0c9a2b" {
+		t.Errorf("want the pointer to the current generation kept, got %v", got.Data)
+	}
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultGeneratedPasswordLength is the length of generated passwords when spec.*.secretsManagerSecretRef.generate.length is omitted
+const defaultGeneratedPasswordLength = 32
+
+// reconcileGenerate creates the Secrets Manager secrets referenced with `generate` that do not exist yet.
+// It runs before provisioning so that the secret is created with the generated value and the provisioned settings at once.
+// The secrets are looked up only once per generation of the AWSSecret, because they are never regenerated once they exist.
+func (r *AWSSecretController) reconcileGenerate(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) error {
+	if cr.Status.GeneratedGeneration == cr.Generation {
+		return nil
+	}
+
+	refs := []struct {
+		ref    mumoshuv1alpha1.SecretsManagerSecretRef
+		encode bool
+	}{
+		{ref: cr.Spec.StringDataFrom.SecretsManagerSecretRef},
+		{ref: cr.Spec.DataFrom.SecretsManagerSecretRef, encode: true},
+	}
+
+	var ensured bool
+
+	for _, s := range refs {
+		if s.ref.Generate == nil || s.ref.SecretId == "" {
+			continue
+		}
+
+		ensured = true
+
+		input := &secretsmanager.CreateSecretInput{Name: aws.String(s.ref.SecretId)}
+		if cr.Spec.Provision != nil {
+			input = createSecretInput(s.ref.SecretId, *cr.Spec.Provision)
+		}
+
+		created, err := r.SyncContext.GenerateSecret(input, *s.ref.Generate, s.encode)
+		if err != nil {
+			if statusErr := r.updateStatus(ctx, cr, setReadyCondition(cr, metav1.ConditionFalse, mumoshuv1alpha1.ReasonGenerateFailed,
+				fmt.Sprintf("Failed to generate Secrets Manager secret %s: %v", s.ref.SecretId, err))); statusErr != nil {
+				return statusErr
+			}
+			return err
+		}
+
+		if created {
+			reqLogger.Info("Generated Secrets Manager secret", "secretId", s.ref.SecretId)
+		}
+	}
+
+	if !ensured {
+		return nil
+	}
+
+	return r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.GeneratedGeneration = cr.Generation
+	})
+}
+
+// GenerateSecret creates the SecretsManager secret with a random password when it does not exist.
+// It never touches the value of an existing secret, including one scheduled for deletion.
+// It returns true when the secret has been created.
+func (c *SyncContext) GenerateSecret(input *secretsmanager.CreateSecretInput, g mumoshuv1alpha1.Generator, encode bool) (bool, error) {
+	secretId := aws.StringValue(input.Name)
+
+	desc, err := c.DescribeSecret(secretId)
+	if err != nil {
+		return false, err
+	}
+
+	if desc != nil {
+		return false, nil
+	}
+
+	output, err := c.client().GetRandomPassword(randomPasswordInput(g))
+	if err != nil {
+		return false, err
+	}
+
+	value, err := generatedSecretString(g, aws.StringValue(output.RandomPassword), encode)
+	if err != nil {
+		return false, err
+	}
+
+	input.SecretString = aws.String(value)
+
+	if _, _, err := c.CreateSecret(input); err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+			// Someone else created the secret in the meantime. Keep theirs.
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func randomPasswordInput(g mumoshuv1alpha1.Generator) *secretsmanager.GetRandomPasswordInput {
+	length := g.Length
+	if length == 0 {
+		length = defaultGeneratedPasswordLength
+	}
+
+	input := &secretsmanager.GetRandomPasswordInput{
+		PasswordLength:     aws.Int64(length),
+		ExcludeNumbers:     aws.Bool(g.ExcludeNumbers),
+		ExcludePunctuation: aws.Bool(g.ExcludePunctuation),
+		ExcludeUppercase:   aws.Bool(g.ExcludeUppercase),
+		ExcludeLowercase:   aws.Bool(g.ExcludeLowercase),
+		IncludeSpace:       aws.Bool(g.IncludeSpace),
+	}
+
+	if g.ExcludeCharacters != "" {
+		input.ExcludeCharacters = aws.String(g.ExcludeCharacters)
+	}
+
+	return input
+}
+
+// generatedSecretString lays out the generated password as the secret value.
+// encode base64-encodes the values of the JSON object, as dataFrom expects.
+func generatedSecretString(g mumoshuv1alpha1.Generator, password string, encode bool) (string, error) {
+	if g.Key == "" {
+		if encode {
+			return "", fmt.Errorf("generate.key is required to generate the secret for dataFrom")
+		}
+		if len(g.Data) > 0 {
+			return "", fmt.Errorf("generate.key is required to store generate.data along with the password")
+		}
+		return password, nil
+	}
+
+	if _, ok := g.Data[g.Key]; ok {
+		return "", fmt.Errorf("generate.data must not contain the password key %s", g.Key)
+	}
+
+	m := map[string]string{}
+	for k, v := range g.Data {
+		m[k] = v
+	}
+	m[g.Key] = password
+
+	if encode {
+		for k, v := range m {
+			m[k] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+	}
+
+	// json.Marshal sorts map keys
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bs), nil
+}
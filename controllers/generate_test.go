@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGeneratedSecretString(t *testing.T) {
+	type testcase struct {
+		name    string
+		gen     mumoshuv1alpha1.Generator
+		encode  bool
+		want    string
+		wantErr bool
+	}
+
+	testcases := []testcase{
+		{name: "plain password", gen: mumoshuv1alpha1.Generator{}, want: "p@ss"},
+		{name: "json key", gen: mumoshuv1alpha1.Generator{Key: "password"}, want: `{"password":"p@ss"}`},
+		{name: "json key with data", gen: mumoshuv1alpha1.Generator{Key: "password", Data: map[string]string{"username": "app"}}, want: `{"password":"p@ss","username":"app"}`},
+		{name: "dataFrom encodes values", gen: mumoshuv1alpha1.Generator{Key: "password", Data: map[string]string{"username": "app"}}, encode: true, want: `{"password":"cEBzcw==","username":"YXBw"}`},
+		{name: "dataFrom requires key", gen: mumoshuv1alpha1.Generator{}, encode: true, wantErr: true},
+		{name: "data requires key", gen: mumoshuv1alpha1.Generator{Data: map[string]string{"username": "app"}}, wantErr: true},
+		{name: "data conflicts with key", gen: mumoshuv1alpha1.Generator{Key: "password", Data: map[string]string{"password": "x"}}, wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := generatedSecretString(tc.gen, "p@ss", tc.encode)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+// describingSecretsManager counts the DescribeSecret calls for the secret that already exists
+type describingSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	describes int
+}
+
+func (f *describingSecretsManager) DescribeSecret(input *secretsmanager.DescribeSecretInput) (*secretsmanager.DescribeSecretOutput, error) {
+	f.describes++
+
+	return &secretsmanager.DescribeSecretOutput{ARN: aws.String("arn:" + aws.StringValue(input.SecretId)), Name: input.SecretId}, nil
+}
+
+func TestReconcileGenerateOncePerGeneration(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", Generation: 1},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret", Generate: &mumoshuv1alpha1.Generator{}},
+			},
+		},
+	}
+
+	sm := &describingSecretsManager{}
+
+	r := &AWSSecretController{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build(),
+		Scheme:      scheme,
+		SyncContext: &SyncContext{sm: sm},
+	}
+
+	get := func() *mumoshuv1alpha1.AWSSecret {
+		t.Helper()
+
+		var got mumoshuv1alpha1.AWSSecret
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(cr), &got); err != nil {
+			t.Fatal(err)
+		}
+		return &got
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := r.reconcileGenerate(ctx, logr.Discard(), get()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sm.describes != 1 {
+		t.Errorf("want the secret described once for the generation, got %d", sm.describes)
+	}
+
+	if got := get().Status.GeneratedGeneration; got != 1 {
+		t.Errorf("want generatedGeneration 1, got %d", got)
+	}
+
+	// A spec change looks the secret up again, in case the secretId has changed
+	updated := get()
+	updated.Generation = 2
+
+	if err := r.reconcileGenerate(ctx, logr.Discard(), updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if sm.describes != 2 {
+		t.Errorf("want the secret described again for the new generation, got %d", sm.describes)
+	}
+}
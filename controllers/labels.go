@@ -0,0 +1,63 @@
+package controllers
+
+const (
+	// keyPrefix is the prefix of all the labels and annotations the operator sets or reads
+	keyPrefix = "aws-secret-operator.mumoshu.github.io/"
+
+	// LabelManagedBy is set on all the Secrets written by AWSSecrets, so that the manager caches only those Secrets
+	LabelManagedBy = keyPrefix + "managed-by"
+
+	// LabelAWSSecret is set on version-suffixed Secrets to the name of the AWSSecret that generated them.
+	// Names longer than a label value are truncated and suffixed with a hash.
+	LabelAWSSecret = keyPrefix + "awssecret"
+
+	// LabelShardGroup is set on the Leases of the replicas running in the sharded mode, to the name of the shard group
+	LabelShardGroup = keyPrefix + "shard-group"
+
+	// Finalizer is added to AWSSecrets to let the controller retain the managed Secrets on deletion when requested
+	Finalizer = keyPrefix + "finalizer"
+
+	// TagPushSecret is the tag set on Secrets Manager secrets created by a PushSecret, to the namespace and name of the PushSecret
+	TagPushSecret = keyPrefix + "push-secret"
+
+	// AnnotationRestartOnChange is set on Deployments, StatefulSets and DaemonSets to a comma-separated list of
+	// AWSSecret names, to opt in to rolling restarts when any of them changes
+	AnnotationRestartOnChange = keyPrefix + "restart-on-change"
+
+	// AnnotationRotateRequestedAt is set on AWSSecrets to request the rotation of the Secrets Manager secret.
+	// The controller rotates the secret once per distinct value, typically a timestamp.
+	AnnotationRotateRequestedAt = keyPrefix + "rotate-requested-at"
+
+	// AnnotationPaused is set to "true" on AWSSecrets to make the controller stop writing the Secret and
+	// the Secrets Manager secret, freezing the Secret at its current content
+	AnnotationPaused = keyPrefix + "paused"
+
+	// AnnotationForceSync is set on AWSSecrets to make the controller refetch the secret values and rewrite the Secret,
+	// bypassing the synced data it would otherwise reuse. The controller force-syncs once per distinct value.
+	AnnotationForceSync = keyPrefix + "force-sync"
+
+	// AnnotationResyncRequestedAt is set by the replica that received a Secrets Manager event for an AWSSecret owned by
+	// another replica in the sharded mode, to the time of the event. It makes the owning replica resync the AWSSecret.
+	AnnotationResyncRequestedAt = keyPrefix + "resync-requested-at"
+
+	// AnnotationSourceVersions is set on the managed Secrets to the VersionIds of the synced source versions,
+	// so that the controller can tell whether the Secret is up to date without reading the secret values
+	AnnotationSourceVersions = keyPrefix + "source-versions"
+
+	// AnnotationDataHash is set on the managed Secrets to the hash of the synced data,
+	// so that the controller can tell whether the data has been modified since it was synced
+	AnnotationDataHash = keyPrefix + "data-hash"
+
+	// managedByAWSSecret is the value of LabelManagedBy
+	managedByAWSSecret = "awssecret"
+
+	// checksumAnnotationPrefix is the prefix of the pod template annotations the controller updates with
+	// the content hash of the Secret to trigger rolling restarts
+	checksumAnnotationPrefix = "checksum." + keyPrefix
+)
+
+// operatorLabels are the labels the operator sets on the managed Secrets, stripped when they are retained
+var operatorLabels = []string{
+	LabelManagedBy,
+	LabelAWSSecret,
+}
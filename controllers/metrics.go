@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "aws_secret_operator"
+
+var (
+	versionStale = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "version_stale",
+		Help:      "1 when the versionId pinned by the AWSSecret is no longer AWSCURRENT, 0 otherwise",
+	}, []string{"namespace", "name", "source"})
+
+	versionsBehind = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "versions_behind",
+		Help:      "Number of versions of the Secrets Manager secret created after the versionId pinned by the AWSSecret",
+	}, []string{"namespace", "name", "source"})
+)
+
+var (
+	sqsMessagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sqs_messages_received_total",
+		Help:      "Number of Secrets Manager change notifications received from SQS",
+	})
+
+	sqsPoisonMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sqs_poison_messages_total",
+		Help:      "Number of SQS messages that couldn't be parsed as Secrets Manager change notifications, counted per receipt",
+	})
+
+	sqsMessageLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "sqs_message_lag_seconds",
+		Help:      "Time from when the change notification was sent to SQS until the affected AWSSecrets were enqueued",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+)
+
+var shardMembers = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "shard_members",
+	Help:      "Number of the live replicas in the shard group, as seen by this replica",
+})
+
+var awsRateLimitWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: metricsNamespace,
+	Name:      "aws_rate_limit_wait_seconds",
+	Help:      "Time Secrets Manager API calls waited for the global rate limiter",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+})
+
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "Number of AWSSecrets waiting to be reconciled, by priority. Low priority ones are the due periodic resyncs",
+	}, []string{"priority"})
+
+	queueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_wait_seconds",
+		Help:      "Time from when an AWSSecret was enqueued, or its periodic resync became due, until it started being reconciled, by priority",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+	}, []string{"priority"})
+)
+
+var (
+	regionCircuitOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "region_circuit_open",
+		Help:      "Whether the circuit breaker of the Secrets Manager region is open, skipping calls to the region until a probe succeeds",
+	}, []string{"region"})
+
+	regionFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "region_failovers_total",
+		Help:      "Number of Secrets Manager reads served by a fallback region, by the region",
+	}, []string{"region"})
+)
+
+func init() {
+	// Served by the controller-runtime metrics server along with the controller metrics
+	metrics.Registry.MustRegister(versionStale, versionsBehind, sqsMessagesReceived, sqsPoisonMessages, sqsMessageLag, shardMembers, awsRateLimitWait, queueDepth, queueWait, regionCircuitOpen, regionFailovers)
+}
+
+// deleteVersionMetrics removes the series of the source of the AWSSecret
+func deleteVersionMetrics(namespace, name, source string) {
+	versionStale.DeleteLabelValues(namespace, name, source)
+	versionsBehind.DeleteLabelValues(namespace, name, source)
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pausedReason returns the reason the AWSSecret is paused for, or an empty string when it isn't paused
+func pausedReason(cr *mumoshuv1alpha1.AWSSecret, global bool) (string, string) {
+	if global {
+		return mumoshuv1alpha1.ReasonPausedGlobally, "The operator is running with --paused"
+	}
+
+	if cr.Annotations[AnnotationPaused] == "true" {
+		return mumoshuv1alpha1.ReasonPausedByAnnotation, "Remove the " + AnnotationPaused + " annotation to resume syncing"
+	}
+
+	return "", ""
+}
+
+// reconcilePause reports whether the AWSSecret is paused in the Paused condition, and returns true when it is paused.
+// The condition is flipped to False when the AWSSecret is resumed, and otherwise left absent.
+func (r *AWSSecretController) reconcilePause(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) (bool, error) {
+	reason, message := pausedReason(cr, r.Paused)
+	if reason != "" {
+		reqLogger.V(1).Info("Skipping the paused awssecret", "reason", reason)
+
+		return true, r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+			setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionPaused, metav1.ConditionTrue, reason, message)
+		})
+	}
+
+	if !meta.IsStatusConditionTrue(cr.Status.Conditions, mumoshuv1alpha1.ConditionPaused) {
+		return false, nil
+	}
+
+	reqLogger.Info("Resuming the paused awssecret")
+
+	return false, r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionPaused, metav1.ConditionFalse, mumoshuv1alpha1.ReasonResumed, "Syncing resumed")
+	})
+}
+
+// forceSyncRequest returns the value of the force-sync annotation when the AWSSecret hasn't been force-synced for it yet,
+// or an empty string otherwise
+func forceSyncRequest(cr *mumoshuv1alpha1.AWSSecret) string {
+	v := cr.Annotations[AnnotationForceSync]
+	if v == cr.Status.LastForceSync {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"testing"
+
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPausedReason(t *testing.T) {
+	type testcase struct {
+		name        string
+		annotations map[string]string
+		global      bool
+		want        string
+	}
+
+	testcases := []testcase{
+		{name: "not paused", want: ""},
+		{name: "paused by annotation", annotations: map[string]string{AnnotationPaused: "true"}, want: mumoshuv1alpha1.ReasonPausedByAnnotation},
+		{name: "annotation other than true", annotations: map[string]string{AnnotationPaused: "false"}, want: ""},
+		{name: "paused globally", global: true, want: mumoshuv1alpha1.ReasonPausedGlobally},
+		{name: "global takes precedence", annotations: map[string]string{AnnotationPaused: "true"}, global: true, want: mumoshuv1alpha1.ReasonPausedGlobally},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cr := &mumoshuv1alpha1.AWSSecret{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			if got, _ := pausedReason(cr, tc.global); got != tc.want {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestForceSyncRequest(t *testing.T) {
+	type testcase struct {
+		name       string
+		annotation string
+		last       string
+		want       string
+	}
+
+	testcases := []testcase{
+		{name: "no annotation", want: ""},
+		{name: "new value", annotation: "2022-03-01T00:00:00Z", want: "2022-03-01T00:00:00Z"},
+		{name: "changed value", annotation: "2022-03-02T00:00:00Z", last: "2022-03-01T00:00:00Z", want: "2022-03-02T00:00:00Z"},
+		{name: "already force-synced", annotation: "2022-03-01T00:00:00Z", last: "2022-03-01T00:00:00Z", want: ""},
+		{name: "annotation removed", last: "2022-03-01T00:00:00Z", want: ""},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cr := &mumoshuv1alpha1.AWSSecret{}
+			if tc.annotation != "" {
+				cr.Annotations = map[string]string{AnnotationForceSync: tc.annotation}
+			}
+			cr.Status.LastForceSync = tc.last
+
+			if got := forceSyncRequest(cr); got != tc.want {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	priorityHigh = "high"
+	priorityLow  = "low"
+
+	// resyncReleaseInterval is how often the due resyncs are checked for release into the work queue
+	resyncReleaseInterval = 100 * time.Millisecond
+)
+
+var _ source.Source = &PriorityQueue{}
+
+// PriorityQueue holds the periodic resyncs of AWSSecrets back until the work queue of the controller is almost drained,
+// so that creations, spec changes and force-sync requests are reconciled ahead of thousands of routine resyncs,
+// like the ones after a restart.
+//
+// The requests enqueued with addHigh go to the work queue immediately, while the resyncs scheduled with
+// resyncAfter are released into the work queue once they are due and the work queue is shorter than the threshold.
+// Due resyncs are released whenever a reconciliation finishes as well as every release interval,
+// so that the resync throughput is bound by the reconciliations rather than by the release interval.
+// The PriorityQueue is a source of the controller, which gives it access to the work queue.
+//
+// The scheduled resyncs outlive the controller, so that a controller restarted in-process with the same PriorityQueue,
+// like when the watched namespaces change, resyncs the already synced AWSSecrets when they are due instead of all at once.
+type PriorityQueue struct {
+	// threshold is the length of the work queue below which due resyncs are released into it
+	threshold int
+
+	mu sync.Mutex
+	// queue is the work queue of the controller, set when the controller starts
+	queue workqueue.RateLimitingInterface
+	// resyncs are the due times of the resyncs held back
+	resyncs map[reconcile.Request]time.Time
+	// enqueued are the requests added to the work queue and not yet started, used to observe the wait time per priority
+	enqueued map[reconcile.Request]queuedRequest
+}
+
+// queuedRequest is the priority of a request in the work queue and the time it became ready to be reconciled
+type queuedRequest struct {
+	priority string
+	since    time.Time
+}
+
+// NewPriorityQueue returns the PriorityQueue releasing due resyncs while the work queue is shorter than the threshold,
+// typically the number of concurrent reconciliations
+func NewPriorityQueue(threshold int) *PriorityQueue {
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	return &PriorityQueue{
+		threshold: threshold,
+		resyncs:   map[reconcile.Request]time.Time{},
+		enqueued:  map[reconcile.Request]queuedRequest{},
+	}
+}
+
+// Start releases the due resyncs into the work queue until the context is done.
+// The requests left in the work queue of the previous controller, if any, are lost with it,
+// so they are scheduled as due resyncs to be released into the new work queue.
+func (p *PriorityQueue) Start(ctx context.Context, _ handler.EventHandler, q workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+	p.mu.Lock()
+	p.queue = q
+	for req, e := range p.enqueued {
+		if d, ok := p.resyncs[req]; !ok || e.since.Before(d) {
+			p.resyncs[req] = e.since
+		}
+		delete(p.enqueued, req)
+	}
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(resyncReleaseInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			p.release(time.Now())
+		}
+	}()
+
+	return nil
+}
+
+// addHigh adds the request to the work queue ahead of the resyncs held back
+func (p *PriorityQueue) addHigh(q workqueue.RateLimitingInterface, req reconcile.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The request reconciles the AWSSecret anyway, which schedules the next resync
+	delete(p.resyncs, req)
+
+	if _, ok := p.enqueued[req]; !ok {
+		p.enqueued[req] = queuedRequest{priority: priorityHigh, since: time.Now()}
+	}
+
+	q.Add(req)
+
+	p.updateDepth()
+}
+
+// resyncAfter schedules the periodic resync of the AWSSecret after the delay
+func (p *PriorityQueue) resyncAfter(req reconcile.Request, delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	due := time.Now().Add(delay)
+
+	if d, ok := p.resyncs[req]; !ok || due.Before(d) {
+		p.resyncs[req] = due
+	}
+
+	p.updateDepth()
+}
+
+// resyncUnlessScheduled schedules the resync of the AWSSecret immediately, unless one is already scheduled,
+// like the one kept from before the controller restarted
+func (p *PriorityQueue) resyncUnlessScheduled(req reconcile.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.resyncs[req]; ok {
+		return
+	}
+
+	p.resyncs[req] = time.Now()
+
+	p.updateDepth()
+}
+
+// started records the wait time of the request the controller started reconciling
+func (p *PriorityQueue) started(req reconcile.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.enqueued[req]
+	if !ok {
+		// Retries after errors and the requests of owned Secrets aren't tracked
+		return
+	}
+
+	delete(p.enqueued, req)
+
+	queueWait.WithLabelValues(e.priority).Observe(time.Since(e.since).Seconds())
+
+	p.updateDepth()
+}
+
+// release adds the due resyncs to the work queue in the fair order, while the work queue is shorter than the threshold
+func (p *PriorityQueue) release(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.queue == nil {
+		return
+	}
+
+	for _, req := range p.dueResyncs(now) {
+		if p.queue.Len() >= p.threshold {
+			break
+		}
+
+		if _, ok := p.enqueued[req]; !ok {
+			p.enqueued[req] = queuedRequest{priority: priorityLow, since: p.resyncs[req]}
+		}
+
+		delete(p.resyncs, req)
+
+		p.queue.Add(req)
+	}
+
+	p.updateDepth()
+}
+
+// dueResyncs returns the due resyncs taking turns among the namespaces, the longest overdue first within each namespace,
+// so that a namespace with thousands of due resyncs doesn't starve the others. Must be called with the lock held.
+func (p *PriorityQueue) dueResyncs(now time.Time) []reconcile.Request {
+	byNamespace := map[string][]reconcile.Request{}
+	for req, t := range p.resyncs {
+		if !t.After(now) {
+			byNamespace[req.Namespace] = append(byNamespace[req.Namespace], req)
+		}
+	}
+
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns, reqs := range byNamespace {
+		sort.Slice(reqs, func(i, j int) bool {
+			return p.resyncs[reqs[i]].Before(p.resyncs[reqs[j]])
+		})
+		namespaces = append(namespaces, ns)
+	}
+
+	// The namespace with the longest overdue resync takes the first turn
+	sort.Slice(namespaces, func(i, j int) bool {
+		return p.resyncs[byNamespace[namespaces[i]][0]].Before(p.resyncs[byNamespace[namespaces[j]][0]])
+	})
+
+	var due []reconcile.Request
+	for turn := 0; ; turn++ {
+		added := false
+		for _, ns := range namespaces {
+			if reqs := byNamespace[ns]; turn < len(reqs) {
+				due = append(due, reqs[turn])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+
+	return due
+}
+
+// updateDepth sets the queue depth metrics. Must be called with the lock held.
+func (p *PriorityQueue) updateDepth() {
+	depth := map[string]int{priorityHigh: 0, priorityLow: 0}
+
+	now := time.Now()
+	for _, t := range p.resyncs {
+		if !t.After(now) {
+			depth[priorityLow]++
+		}
+	}
+
+	for _, e := range p.enqueued {
+		depth[e.priority]++
+	}
+
+	for priority, n := range depth {
+		queueDepth.WithLabelValues(priority).Set(float64(n))
+	}
+}
+
+var _ handler.EventHandler = &priorityHandler{}
+
+// priorityHandler enqueues AWSSecrets that need syncing, like new and changed ones, with the high priority,
+// and holds back the ones that are already synced, like the ones listed after a restart, as periodic resyncs.
+// The already synced ones keep the resyncs scheduled before the restart, if any.
+type priorityHandler struct {
+	queue *PriorityQueue
+}
+
+func (h *priorityHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	if cr, ok := e.Object.(*mumoshuv1alpha1.AWSSecret); ok && synced(cr) {
+		h.queue.resyncUnlessScheduled(requestFor(e.Object))
+		return
+	}
+
+	h.queue.addHigh(q, requestFor(e.Object))
+}
+
+func (h *priorityHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	if !specChanged(e.ObjectOld, e.ObjectNew) {
+		// Status updates, including the ones made by the controller, are covered by the resync scheduled by the reconciliation.
+		// Scheduling another one would replace its jitter and the retry after quota exhaustion.
+		return
+	}
+
+	h.queue.addHigh(q, requestFor(e.ObjectNew))
+}
+
+func (h *priorityHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.queue.addHigh(q, requestFor(e.Object))
+}
+
+func (h *priorityHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.queue.addHigh(q, requestFor(e.Object))
+}
+
+func requestFor(obj client.Object) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}}
+}
+
+// synced returns true when the Secret has been synced for the current spec, and neither force-sync nor deletion is pending
+func synced(cr *mumoshuv1alpha1.AWSSecret) bool {
+	return cr.DeletionTimestamp == nil && cr.Status.SyncedGeneration == cr.Generation && forceSyncRequest(cr) == ""
+}
+
+// specChanged returns true when the update may require the controller to act,
+// like changes to the spec, the annotations including force-sync and paused, and the deletion
+func specChanged(oldObj, newObj client.Object) bool {
+	return oldObj.GetGeneration() != newObj.GetGeneration() ||
+		!reflect.DeepEqual(oldObj.GetAnnotations(), newObj.GetAnnotations()) ||
+		!reflect.DeepEqual(oldObj.GetFinalizers(), newObj.GetFinalizers()) ||
+		!reflect.DeepEqual(oldObj.GetDeletionTimestamp(), newObj.GetDeletionTimestamp())
+}
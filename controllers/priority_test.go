@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func awsSecretRequest(name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}
+}
+
+func TestPriorityQueueRelease(t *testing.T) {
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	p := NewPriorityQueue(1)
+	p.queue = q
+
+	p.resyncAfter(awsSecretRequest("later"), time.Hour)
+	p.resyncAfter(awsSecretRequest("overdue"), -2*time.Second)
+	p.resyncAfter(awsSecretRequest("due"), -time.Second)
+
+	// Only the longest overdue resync is released while the work queue is busy
+	p.release(time.Now())
+	if got := q.Len(); got != 1 {
+		t.Fatalf("want 1 released resync, got %d", got)
+	}
+
+	// The new AWSSecret waits behind at most the threshold of resyncs, instead of all the due ones
+	p.addHigh(q, awsSecretRequest("new"))
+	p.release(time.Now())
+
+	var got []string
+	for q.Len() > 0 {
+		item, _ := q.Get()
+		req := item.(reconcile.Request)
+		p.started(req)
+		got = append(got, req.Name)
+		q.Done(item)
+	}
+
+	if want := []string{"overdue", "new"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("unexpected order: want %v, got %v", want, got)
+	}
+
+	p.release(time.Now())
+	if item, _ := q.Get(); item.(reconcile.Request).Name != "due" {
+		t.Errorf("want the remaining due resync released after the work queue is drained, got %v", item)
+	}
+
+	if _, ok := p.resyncs[awsSecretRequest("later")]; !ok {
+		t.Error("the resync not yet due has been released")
+	}
+}
+
+func TestPriorityHandler(t *testing.T) {
+	synced := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "synced", Generation: 2},
+		Status:     mumoshuv1alpha1.AWSSecretStatus{SyncedGeneration: 2},
+	}
+
+	changed := synced.DeepCopy()
+	changed.Name = "changed"
+	changed.Generation = 3
+
+	forceSync := synced.DeepCopy()
+	forceSync.Name = "force-sync"
+	forceSync.Annotations = map[string]string{AnnotationForceSync: "1"}
+
+	statusOnly := synced.DeepCopy()
+	statusOnly.Name = "status-only"
+	statusOnly.Status.CurrentSecretName = "status-only"
+
+	testcases := []struct {
+		name string
+		send func(h *priorityHandler, q workqueue.RateLimitingInterface)
+		high bool
+		// ignored is true when the event neither enqueues nor schedules a resync
+		ignored bool
+	}{
+		{
+			name: "synced awssecret listed after a restart",
+			send: func(h *priorityHandler, q workqueue.RateLimitingInterface) {
+				h.Create(event.CreateEvent{Object: synced}, q)
+			},
+		},
+		{
+			name: "new awssecret",
+			send: func(h *priorityHandler, q workqueue.RateLimitingInterface) {
+				h.Create(event.CreateEvent{Object: changed}, q)
+			},
+			high: true,
+		},
+		{
+			name: "spec change",
+			send: func(h *priorityHandler, q workqueue.RateLimitingInterface) {
+				old := changed.DeepCopy()
+				old.Generation = 2
+				h.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: changed}, q)
+			},
+			high: true,
+		},
+		{
+			name: "force-sync request",
+			send: func(h *priorityHandler, q workqueue.RateLimitingInterface) {
+				h.Update(event.UpdateEvent{ObjectOld: synced, ObjectNew: forceSync}, q)
+			},
+			high: true,
+		},
+		{
+			name: "status update",
+			send: func(h *priorityHandler, q workqueue.RateLimitingInterface) {
+				h.Update(event.UpdateEvent{ObjectOld: synced, ObjectNew: statusOnly}, q)
+			},
+			ignored: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+
+			p := NewPriorityQueue(1)
+			tc.send(&priorityHandler{queue: p}, q)
+
+			if got := q.Len() == 1; got != tc.high {
+				t.Errorf("want enqueued with the high priority %v, got %v", tc.high, got)
+			}
+
+			if got := len(p.resyncs) == 1; got != (!tc.high && !tc.ignored) {
+				t.Errorf("want held back as a resync %v, got %v", !tc.high && !tc.ignored, got)
+			}
+		})
+	}
+}
+
+func TestPriorityQueueKeptAcrossRestarts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	old := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer old.ShutDown()
+
+	p := NewPriorityQueue(2)
+	p.queue = old
+
+	// One resync is scheduled later, and another one released into the work queue of the previous controller
+	p.resyncAfter(awsSecretRequest("synced"), time.Hour)
+	p.resyncAfter(awsSecretRequest("released"), -time.Second)
+	p.release(time.Now())
+
+	if old.Len() != 1 {
+		t.Fatalf("want 1 released resync, got %d", old.Len())
+	}
+
+	// The controller restarts with a new work queue and lists the synced awssecrets again
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	if err := p.Start(ctx, nil, q); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &priorityHandler{queue: p}
+	for _, name := range []string{"synced", "released"} {
+		h.Create(event.CreateEvent{Object: &mumoshuv1alpha1.AWSSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Generation: 1},
+			Status:     mumoshuv1alpha1.AWSSecretStatus{SyncedGeneration: 1},
+		}}, q)
+	}
+
+	if due := p.resyncs[awsSecretRequest("synced")]; time.Until(due) < 59*time.Minute {
+		t.Errorf("want the resync scheduled before the restart kept, got due in %s", time.Until(due))
+	}
+
+	// The resync lost with the previous work queue is released into the new one
+	p.release(time.Now())
+
+	if q.Len() != 1 {
+		t.Fatalf("want 1 released resync after the restart, got %d", q.Len())
+	}
+	if item, _ := q.Get(); item.(reconcile.Request).Name != "released" {
+		t.Errorf("want the resync released before the restart released again, got %v", item)
+	}
+}
+
+func TestPriorityQueueFairness(t *testing.T) {
+	p := NewPriorityQueue(1)
+
+	// The noisy namespace has the longest overdue resyncs
+	for i := 0; i < 3; i++ {
+		p.resyncAfter(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "noisy", Name: fmt.Sprintf("example-%d", i)}}, time.Duration(i-10)*time.Second)
+	}
+	p.resyncAfter(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "quiet", Name: "example"}}, -time.Second)
+
+	var got []string
+	for _, req := range p.dueResyncs(time.Now()) {
+		got = append(got, req.String())
+	}
+
+	want := []string{"noisy/example-0", "quiet/example", "noisy/example-1", "noisy/example-2"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func TestReleaseOnReconcile(t *testing.T) {
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	scheme := newTestScheme(t)
+
+	r := &AWSSecretController{
+		Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Scheme: scheme,
+		queue:  NewPriorityQueue(1),
+	}
+	r.queue.queue = q
+
+	for i := 0; i < 3; i++ {
+		r.queue.resyncAfter(awsSecretRequest(fmt.Sprintf("due-%d", i)), -time.Second)
+	}
+
+	// The first resync is released by the ticker
+	r.queue.release(time.Now())
+
+	// Each finished reconciliation releases the next due resync without waiting for the release interval
+	for i := 0; i < 3; i++ {
+		if q.Len() != 1 {
+			t.Fatalf("want 1 released resync before reconciliation %d, got %d", i, q.Len())
+		}
+
+		item, _ := q.Get()
+		if _, err := r.Reconcile(context.Background(), item.(reconcile.Request)); err != nil {
+			t.Fatal(err)
+		}
+		q.Done(item)
+	}
+
+	if q.Len() != 0 {
+		t.Errorf("want no more resyncs, got %d", q.Len())
+	}
+}
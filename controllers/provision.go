@@ -0,0 +1,369 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultDriftCheckInterval is the interval of the drift checks of provisioned secrets when DriftCheckInterval is omitted
+const defaultDriftCheckInterval = time.Hour
+
+// reconcileProvision creates or updates the Secrets Manager secret referenced by the AWSSecret according to spec.provision,
+// and reports the outcome in status.
+// The secret is described only when the check is due, so that resyncs of unchanged AWSSecrets make no Secrets Manager API calls.
+func (r *AWSSecretController) reconcileProvision(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) error {
+	now := time.Now()
+	if !r.driftCheckDue(cr, now) {
+		return nil
+	}
+
+	checkedAt := metav1.NewTime(now)
+
+	secretId, err := sourceSecretId(cr)
+	if err != nil {
+		return r.provisionFailed(ctx, cr, err)
+	}
+
+	arn, drift, created, err := r.SyncContext.ProvisionSecret(secretId, *cr.Spec.Provision)
+	if err != nil {
+		return r.provisionFailed(ctx, cr, err)
+	}
+
+	reason, message := mumoshuv1alpha1.ReasonInSync, fmt.Sprintf("Secrets Manager secret %s is in sync", secretId)
+	if created {
+		reason, message = mumoshuv1alpha1.ReasonCreated, fmt.Sprintf("Created Secrets Manager secret %s", secretId)
+		reqLogger.Info("Created Secrets Manager secret", "secretId", secretId)
+	} else if len(drift) > 0 {
+		reason, message = mumoshuv1alpha1.ReasonDriftCorrected, fmt.Sprintf("Corrected drifted settings of Secrets Manager secret %s: %s", secretId, strings.Join(drift, ", "))
+		reqLogger.Info("Corrected drifted settings of Secrets Manager secret", "secretId", secretId, "drift", drift)
+	}
+
+	return r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.Provision = &mumoshuv1alpha1.ProvisionStatus{ARN: arn, Drift: drift, CheckedAt: &checkedAt}
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionProvisioned, metav1.ConditionTrue, reason, message)
+	})
+}
+
+// driftCheckDue returns true when the provisioned secret hasn't been in sync since the spec changed,
+// or hasn't been checked for the drift check interval
+func (r *AWSSecretController) driftCheckDue(cr *mumoshuv1alpha1.AWSSecret, now time.Time) bool {
+	c := meta.FindStatusCondition(cr.Status.Conditions, mumoshuv1alpha1.ConditionProvisioned)
+	if c == nil || c.Status != metav1.ConditionTrue || c.ObservedGeneration != cr.Generation || cr.Status.Provision == nil || cr.Status.Provision.CheckedAt == nil {
+		return true
+	}
+
+	return !now.Before(cr.Status.Provision.CheckedAt.Add(r.driftCheckInterval()))
+}
+
+func (r *AWSSecretController) driftCheckInterval() time.Duration {
+	if r.DriftCheckInterval > 0 {
+		return r.DriftCheckInterval
+	}
+	return defaultDriftCheckInterval
+}
+
+func (r *AWSSecretController) provisionFailed(ctx context.Context, cr *mumoshuv1alpha1.AWSSecret, err error) error {
+	if statusErr := r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionProvisioned, metav1.ConditionFalse, mumoshuv1alpha1.ReasonProvisionFailed, err.Error())
+	}); statusErr != nil {
+		return statusErr
+	}
+
+	return err
+}
+
+// sourceSecretId returns the SecretId of the single Secrets Manager secret referenced by the AWSSecret
+func sourceSecretId(cr *mumoshuv1alpha1.AWSSecret) (string, error) {
+	a, b := cr.Spec.StringDataFrom.SecretsManagerSecretRef.SecretId, cr.Spec.DataFrom.SecretsManagerSecretRef.SecretId
+
+	switch {
+	case a != "" && b != "" && a != b:
+		return "", fmt.Errorf("stringDataFrom and dataFrom must reference the same secret to be provisioned: got %s and %s", a, b)
+	case a != "":
+		return a, nil
+	case b != "":
+		return b, nil
+	}
+
+	return "", fmt.Errorf("either stringDataFrom or dataFrom must reference the secret to be provisioned")
+}
+
+// ProvisionSecret creates the SecretsManager secret without any value when it does not exist,
+// or corrects the settings that drifted from the spec. It returns the ARN of the secret and the drifted settings.
+func (c *SyncContext) ProvisionSecret(secretId string, p mumoshuv1alpha1.Provision) (string, []string, bool, error) {
+	desc, err := c.DescribeSecret(secretId)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	if desc == nil {
+		arn, _, err := c.CreateSecret(createSecretInput(secretId, p))
+		if err != nil {
+			return "", nil, false, err
+		}
+
+		if p.ResourcePolicy != "" {
+			if _, err := c.client().PutResourcePolicy(&secretsmanager.PutResourcePolicyInput{
+				SecretId:       &secretId,
+				ResourcePolicy: aws.String(p.ResourcePolicy),
+			}); err != nil {
+				return "", nil, false, err
+			}
+		}
+
+		if p.Rotation != nil {
+			if err := c.configureRotation(secretId, *p.Rotation); err != nil {
+				return "", nil, false, err
+			}
+		}
+
+		return aws.StringValue(arn), nil, true, nil
+	}
+
+	var policy *string
+	if p.ResourcePolicy != "" {
+		output, err := c.client().GetResourcePolicy(&secretsmanager.GetResourcePolicyInput{SecretId: &secretId})
+		if err != nil {
+			return "", nil, false, err
+		}
+		policy = output.ResourcePolicy
+	}
+
+	drift := provisionDrift(desc, policy, p)
+
+	for _, d := range drift {
+		var err error
+
+		switch d {
+		case "description":
+			_, err = c.client().UpdateSecret(&secretsmanager.UpdateSecretInput{SecretId: &secretId, Description: aws.String(p.Description)})
+		case "kmsKeyId":
+			_, err = c.client().UpdateSecret(&secretsmanager.UpdateSecretInput{SecretId: &secretId, KmsKeyId: aws.String(p.KmsKeyId)})
+		case "tags":
+			_, err = c.client().TagResource(&secretsmanager.TagResourceInput{SecretId: &secretId, Tags: toTags(p.Tags)})
+		case "replicaRegions":
+			err = c.reconcileReplicaRegions(secretId, desc.ReplicationStatus, p.ReplicaRegions)
+		case "resourcePolicy":
+			_, err = c.client().PutResourcePolicy(&secretsmanager.PutResourcePolicyInput{SecretId: &secretId, ResourcePolicy: aws.String(p.ResourcePolicy)})
+		case "rotation":
+			err = c.configureRotation(secretId, *p.Rotation)
+		}
+
+		if err != nil {
+			return "", nil, false, fmt.Errorf("updating %s: %w", d, err)
+		}
+	}
+
+	return aws.StringValue(desc.ARN), drift, false, nil
+}
+
+func (c *SyncContext) reconcileReplicaRegions(secretId string, current []*secretsmanager.ReplicationStatusType, desired []mumoshuv1alpha1.ReplicaRegion) error {
+	currentRegions := map[string]bool{}
+	for _, r := range current {
+		currentRegions[aws.StringValue(r.Region)] = true
+	}
+
+	desiredRegions := map[string]bool{}
+
+	var add []*secretsmanager.ReplicaRegionType
+	for _, r := range desired {
+		desiredRegions[r.Region] = true
+
+		if currentRegions[r.Region] {
+			continue
+		}
+
+		replica := &secretsmanager.ReplicaRegionType{Region: aws.String(r.Region)}
+		if r.KmsKeyId != "" {
+			replica.KmsKeyId = aws.String(r.KmsKeyId)
+		}
+		add = append(add, replica)
+	}
+
+	var remove []*string
+	for _, r := range current {
+		if !desiredRegions[aws.StringValue(r.Region)] {
+			remove = append(remove, r.Region)
+		}
+	}
+
+	if len(add) > 0 {
+		if _, err := c.client().ReplicateSecretToRegions(&secretsmanager.ReplicateSecretToRegionsInput{
+			SecretId:          &secretId,
+			AddReplicaRegions: add,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if len(remove) > 0 {
+		if _, err := c.client().RemoveRegionsFromReplication(&secretsmanager.RemoveRegionsFromReplicationInput{
+			SecretId:             &secretId,
+			RemoveReplicaRegions: remove,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configureRotation enables the rotation of the secret without rotating it immediately
+func (c *SyncContext) configureRotation(secretId string, r mumoshuv1alpha1.Rotation) error {
+	_, err := c.client().RotateSecret(&secretsmanager.RotateSecretInput{
+		SecretId:          &secretId,
+		RotationLambdaARN: aws.String(r.LambdaARN),
+		RotationRules:     rotationRules(r),
+		RotateImmediately: aws.Bool(false),
+	})
+	return err
+}
+
+// createSecretInput returns the input to create the secret with the provisioned settings.
+// Resource policies and rotation can't be set on creation and need to be configured afterwards.
+func createSecretInput(secretId string, p mumoshuv1alpha1.Provision) *secretsmanager.CreateSecretInput {
+	input := &secretsmanager.CreateSecretInput{
+		Name: aws.String(secretId),
+		Tags: toTags(p.Tags),
+	}
+
+	if p.Description != "" {
+		input.Description = aws.String(p.Description)
+	}
+
+	if p.KmsKeyId != "" {
+		input.KmsKeyId = aws.String(p.KmsKeyId)
+	}
+
+	for _, r := range p.ReplicaRegions {
+		replica := &secretsmanager.ReplicaRegionType{Region: aws.String(r.Region)}
+		if r.KmsKeyId != "" {
+			replica.KmsKeyId = aws.String(r.KmsKeyId)
+		}
+		input.AddReplicaRegions = append(input.AddReplicaRegions, replica)
+	}
+
+	return input
+}
+
+// provisionDrift returns the settings of the described secret that are out of sync with the spec.
+// policy is the current resource policy of the secret, fetched only when the spec manages it.
+func provisionDrift(desc *secretsmanager.DescribeSecretOutput, policy *string, p mumoshuv1alpha1.Provision) []string {
+	var drift []string
+
+	if p.Description != "" && aws.StringValue(desc.Description) != p.Description {
+		drift = append(drift, "description")
+	}
+
+	if p.KmsKeyId != "" && !kmsKeyMatches(aws.StringValue(desc.KmsKeyId), p.KmsKeyId) {
+		drift = append(drift, "kmsKeyId")
+	}
+
+	for k, v := range p.Tags {
+		if !hasTag(desc.Tags, k, v) {
+			drift = append(drift, "tags")
+			break
+		}
+	}
+
+	if p.ReplicaRegions != nil {
+		current := map[string]bool{}
+		for _, r := range desc.ReplicationStatus {
+			current[aws.StringValue(r.Region)] = true
+		}
+
+		desired := map[string]bool{}
+		for _, r := range p.ReplicaRegions {
+			desired[r.Region] = true
+		}
+
+		if !reflect.DeepEqual(current, desired) {
+			drift = append(drift, "replicaRegions")
+		}
+	}
+
+	if p.ResourcePolicy != "" && !jsonEqual(aws.StringValue(policy), p.ResourcePolicy) {
+		drift = append(drift, "resourcePolicy")
+	}
+
+	if r := p.Rotation; r != nil {
+		if !aws.BoolValue(desc.RotationEnabled) || aws.StringValue(desc.RotationLambdaARN) != r.LambdaARN || !rotationRulesMatch(desc.RotationRules, *r) {
+			drift = append(drift, "rotation")
+		}
+	}
+
+	return drift
+}
+
+func rotationRules(r mumoshuv1alpha1.Rotation) *secretsmanager.RotationRulesType {
+	rules := &secretsmanager.RotationRulesType{}
+
+	if r.AutomaticallyAfterDays > 0 {
+		rules.AutomaticallyAfterDays = aws.Int64(r.AutomaticallyAfterDays)
+	}
+
+	if r.ScheduleExpression != "" {
+		rules.ScheduleExpression = aws.String(r.ScheduleExpression)
+	}
+
+	if r.Duration != "" {
+		rules.Duration = aws.String(r.Duration)
+	}
+
+	return rules
+}
+
+func rotationRulesMatch(current *secretsmanager.RotationRulesType, r mumoshuv1alpha1.Rotation) bool {
+	if current == nil {
+		current = &secretsmanager.RotationRulesType{}
+	}
+
+	if r.AutomaticallyAfterDays > 0 && aws.Int64Value(current.AutomaticallyAfterDays) != r.AutomaticallyAfterDays {
+		return false
+	}
+
+	if r.ScheduleExpression != "" && aws.StringValue(current.ScheduleExpression) != r.ScheduleExpression {
+		return false
+	}
+
+	if r.Duration != "" && aws.StringValue(current.Duration) != r.Duration {
+		return false
+	}
+
+	return true
+}
+
+// kmsKeyMatches returns true when the key ID or alias ARN reported by Secrets Manager refers to the desired key ID, alias or ARN
+func kmsKeyMatches(current, desired string) bool {
+	return current == desired || strings.HasSuffix(current, ":"+desired) || strings.HasSuffix(current, "/"+desired)
+}
+
+func jsonEqual(a, b string) bool {
+	var x, y interface{}
+	if err := json.Unmarshal([]byte(a), &x); err != nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func toTags(m map[string]string) []*secretsmanager.Tag {
+	var tags []*secretsmanager.Tag
+	for _, k := range sortedKeys(m) {
+		tags = append(tags, &secretsmanager.Tag{Key: aws.String(k), Value: aws.String(m[k])})
+	}
+	return tags
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestProvisionDrift(t *testing.T) {
+	desc := &secretsmanager.DescribeSecretOutput{
+		Description: aws.String("my secret"),
+		KmsKeyId:    aws.String("arn:aws:kms:us-east-1:123456789012:alias/myapp"),
+		Tags: []*secretsmanager.Tag{
+			{Key: aws.String("team"), Value: aws.String("myteam")},
+			{Key: aws.String("added-by-others"), Value: aws.String("foo")},
+		},
+		ReplicationStatus: []*secretsmanager.ReplicationStatusType{
+			{Region: aws.String("us-west-2")},
+		},
+		RotationEnabled:   aws.Bool(true),
+		RotationLambdaARN: aws.String("arn:aws:lambda:us-east-1:123456789012:function:rotate"),
+		RotationRules:     &secretsmanager.RotationRulesType{AutomaticallyAfterDays: aws.Int64(30)},
+	}
+	policy := aws.String(`{"Version": "2012-10-17", "Statement": []}`)
+
+	inSync := mumoshuv1alpha1.Provision{
+		Description:    "my secret",
+		KmsKeyId:       "alias/myapp",
+		Tags:           map[string]string{"team": "myteam"},
+		ReplicaRegions: []mumoshuv1alpha1.ReplicaRegion{{Region: "us-west-2"}},
+		ResourcePolicy: `{"Statement":[],"Version":"2012-10-17"}`,
+		Rotation: &mumoshuv1alpha1.Rotation{
+			LambdaARN:              "arn:aws:lambda:us-east-1:123456789012:function:rotate",
+			AutomaticallyAfterDays: 30,
+		},
+	}
+
+	type testcase struct {
+		name   string
+		modify func(p *mumoshuv1alpha1.Provision)
+		want   []string
+	}
+
+	testcases := []testcase{
+		{
+			name:   "in sync",
+			modify: func(p *mumoshuv1alpha1.Provision) {},
+		},
+		{
+			name:   "unmanaged settings",
+			modify: func(p *mumoshuv1alpha1.Provision) { *p = mumoshuv1alpha1.Provision{} },
+		},
+		{
+			name: "drifted",
+			modify: func(p *mumoshuv1alpha1.Provision) {
+				p.Description = "new description"
+				p.KmsKeyId = "alias/other"
+				p.Tags["team"] = "otherteam"
+				p.ReplicaRegions = []mumoshuv1alpha1.ReplicaRegion{{Region: "eu-west-1"}}
+				p.ResourcePolicy = `{"Version":"2012-10-17","Statement":[{}]}`
+				p.Rotation.AutomaticallyAfterDays = 7
+			},
+			want: []string{"description", "kmsKeyId", "tags", "replicaRegions", "resourcePolicy", "rotation"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			p := *inSync.DeepCopy()
+			tc.modify(&p)
+
+			got := provisionDrift(desc, policy, p)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected drift:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProvisionDriftCheckInterval(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", Generation: 1},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret"},
+			},
+			Provision: &mumoshuv1alpha1.Provision{},
+		},
+	}
+
+	sm := &describingSecretsManager{}
+
+	r := &AWSSecretController{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build(),
+		Scheme:      scheme,
+		SyncContext: &SyncContext{sm: sm},
+	}
+
+	check := func() {
+		t.Helper()
+
+		var got mumoshuv1alpha1.AWSSecret
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.reconcileProvision(ctx, logr.Discard(), &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+	check()
+
+	if sm.describes != 1 {
+		t.Errorf("want the secret described once until the check is due, got %d calls", sm.describes)
+	}
+
+	var got mumoshuv1alpha1.AWSSecret
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Provision == nil || got.Status.Provision.CheckedAt == nil {
+		t.Fatal("want provision.checkedAt recorded in the status")
+	}
+
+	// A spec change makes the check due
+	got.Generation = 2
+	if err := r.Client.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	check()
+
+	if sm.describes != 2 {
+		t.Errorf("want the secret described again after a spec change, got %d calls", sm.describes)
+	}
+
+	// So does the end of the interval
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if r.driftCheckDue(&got, time.Now()) {
+		t.Error("want no check due right after a check")
+	}
+	if !r.driftCheckDue(&got, got.Status.Provision.CheckedAt.Add(defaultDriftCheckInterval)) {
+		t.Error("want a check due after the drift check interval")
+	}
+}
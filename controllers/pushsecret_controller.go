@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	errs "github.com/pkg/errors"
+)
+
+// pushSecretSecretRefIndex is the field index of PushSecrets by the name of the Kubernetes Secret they push
+const pushSecretSecretRefIndex = "spec.secretRef.name"
+
+func (r *PushSecretController) SetupWithManager(mgr ctrl.Manager) error {
+	var name = "pushsecret-controller"
+
+	if r.Name != "" {
+		name = r.Name
+	}
+
+	if r.SyncContext == nil {
+		r.SyncContext = NewSyncContext(nil, nil)
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), &mumoshuv1alpha1.PushSecret{}, pushSecretSecretRefIndex, func(o client.Object) []string {
+		return []string{o.(*mumoshuv1alpha1.PushSecret).Spec.SecretRef.Name}
+	}); err != nil {
+		return err
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&mumoshuv1alpha1.PushSecret{})
+
+	// The manager cache holds only the managed Secrets, which the pushed Secrets usually aren't
+	if r.SecretMetadataInformers == nil {
+		b = b.Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.pushSecretsForSecret))
+	}
+	for _, i := range r.SecretMetadataInformers {
+		b = b.Watches(&source.Informer{Informer: i}, handler.EnqueueRequestsFromMapFunc(r.pushSecretsForSecret))
+	}
+
+	if r.Events != nil {
+		b = b.Watches(&source.Channel{Source: r.Events}, &handler.EnqueueRequestForObject{})
+	}
+
+	return b.Named(name).Complete(r)
+}
+
+var _ reconcile.Reconciler = &PushSecretController{}
+
+// PushSecretController reconciles a PushSecret object, by pushing the data of the referenced Kubernetes Secret
+// to Secrets Manager whenever it changes
+type PushSecretController struct {
+	Name string
+
+	Client client.Client
+	Scheme *runtime.Scheme
+
+	// APIReader reads the pushed Secrets, which are missing from the cache unless they are managed by the operator.
+	// Client is used instead when nil.
+	APIReader client.Reader
+
+	// SecretMetadataInformers watch the metadata of all the Secrets in the watched namespaces regardless of their labels,
+	// so that changes to the pushed Secrets are pushed immediately. Only the Secrets in the manager cache are watched when nil.
+	SecretMetadataInformers []*SecretMetadataInformer
+
+	// HashKey is the operator key the content hashes of the pushed data are keyed with, along with the UID of each PushSecret,
+	// so that the hashes recorded in the status can't be used to guess the data by hashing candidate values
+	HashKey []byte
+
+	// RefreshInterval is the interval at which the pushed Secrets are checked for changes in case a change was missed. Defaults to 5 minutes.
+	RefreshInterval time.Duration
+
+	// Shard makes the controller push only the PushSecrets assigned to this replica in the sharded mode,
+	// so that replicas don't put duplicate versions or race on creating the Secrets Manager secret.
+	// All PushSecrets are pushed when nil.
+	Shard *Shard
+
+	// Events enqueues the PushSecrets this replica starts owning on rebalancing in the sharded mode
+	Events chan event.GenericEvent
+
+	SyncContext *SyncContext
+	Log         *logr.Logger
+}
+
+// pushSecretsForSecret maps a Kubernetes Secret to the PushSecrets that push it
+func (r *PushSecretController) pushSecretsForSecret(obj client.Object) []reconcile.Request {
+	var list mumoshuv1alpha1.PushSecretList
+	if err := r.Client.List(context.TODO(), &list, client.InNamespace(obj.GetNamespace()), client.MatchingFields{pushSecretSecretRefIndex: obj.GetName()}); err != nil {
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, ps := range list.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: ps.Namespace, Name: ps.Name}})
+	}
+
+	return reqs
+}
+
+// Reconcile pushes the data of the Kubernetes Secret referenced by the PushSecret to Secrets Manager,
+// when the content or the PushSecret spec has changed since the last push.
+func (r *PushSecretController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	var log logr.Logger
+	if r.Log != nil {
+		log = *r.Log
+	} else {
+		log = logf.Log
+	}
+
+	reqLogger := log.WithName("controller_pushsecret").WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+
+	if r.Shard != nil && !r.Shard.Owns(request.NamespacedName) {
+		// Pushed by the replica owning it. Enqueued again if this replica owns it after rebalancing
+		reqLogger.V(1).Info("Skipping the pushsecret owned by another replica")
+		return reconcile.Result{}, nil
+	}
+
+	instance := &mumoshuv1alpha1.PushSecret{}
+	if err := r.Client.Get(ctx, request.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// Changes are normally enqueued by the watch on the Secret. The periodic resync catches up on missed ones
+	requeue := reconcile.Result{RequeueAfter: jitteredRefresh(instance, r.refreshInterval(), time.Now())}
+
+	var reader client.Reader = r.Client
+	if r.APIReader != nil {
+		reader = r.APIReader
+	}
+
+	secret := &corev1.Secret{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: instance.Spec.SecretRef.Name}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return requeue, r.updateStatus(ctx, instance, setPushSecretReadyCondition(instance, metav1.ConditionFalse, mumoshuv1alpha1.ReasonSecretNotFound,
+				fmt.Sprintf("Secret %s does not exist", instance.Spec.SecretRef.Name)))
+		}
+		return reconcile.Result{}, err
+	}
+
+	value, err := pushSecretValue(secret, instance.Spec.Keys)
+	if err != nil {
+		return reconcile.Result{}, r.updateStatus(ctx, instance, setPushSecretReadyCondition(instance, metav1.ConditionFalse, mumoshuv1alpha1.ReasonKeyNotFound, err.Error()))
+	}
+
+	hash := pushSecretContentHash(r.HashKey, instance, value)
+
+	if hash == instance.Status.ContentHash && instance.Generation == instance.Status.ObservedGeneration {
+		return requeue, nil
+	}
+
+	target := instance.Spec.SecretsManagerSecret
+
+	arn, versionId, reason, err := r.push(reqLogger, instance, target, value)
+	if err != nil {
+		if reason == "" {
+			reason = mumoshuv1alpha1.ReasonPushFailed
+		}
+		if statusErr := r.updateStatus(ctx, instance, setPushSecretReadyCondition(instance, metav1.ConditionFalse, reason, err.Error())); statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		if reason == mumoshuv1alpha1.ReasonNotManaged {
+			// Retrying won't help until the PushSecret or the Secrets Manager secret is changed
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errs.Wrap(err, "failed to push secret")
+	}
+
+	reqLogger.Info("Pushed Secret to Secrets Manager", "secretId", target.SecretId, "versionId", versionId)
+
+	return requeue, r.updateStatus(ctx, instance, func(st *mumoshuv1alpha1.PushSecretStatus) {
+		st.ARN = arn
+		st.VersionId = versionId
+		st.ContentHash = hash
+		st.ObservedGeneration = instance.Generation
+		setPushSecretReadyCondition(instance, metav1.ConditionTrue, mumoshuv1alpha1.ReasonPushed,
+			fmt.Sprintf("Pushed version %s to %s", versionId, target.SecretId))(st)
+	})
+}
+
+// pushSecretContentHash returns the HMAC-SHA256 of the value keyed with the operator key and the UID of the PushSecret
+func pushSecretContentHash(key []byte, cr *mumoshuv1alpha1.PushSecret, value string) string {
+	mac := hmac.New(sha256.New, append(append([]byte{}, key...), cr.UID...))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// push creates the Secrets Manager secret with the value, or puts the value as a new version of the existing secret.
+// It returns the reason along with the error when the push was refused.
+func (r *PushSecretController) push(reqLogger logr.Logger, cr *mumoshuv1alpha1.PushSecret, target mumoshuv1alpha1.PushSecretTarget, value string) (string, string, string, error) {
+	owner := pushSecretOwnerTagValue(cr)
+
+	desc, err := r.SyncContext.DescribeSecret(target.SecretId)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if desc == nil {
+		reqLogger.Info("Secrets Manager secret does not exist, Creating a new secret", "secretId", target.SecretId)
+
+		input := &secretsmanager.CreateSecretInput{
+			Name:         aws.String(target.SecretId),
+			SecretString: aws.String(value),
+			Tags:         append([]*secretsmanager.Tag{{Key: aws.String(TagPushSecret), Value: aws.String(owner)}}, toTags(target.Tags)...),
+		}
+		if target.KmsKeyId != "" {
+			input.KmsKeyId = aws.String(target.KmsKeyId)
+		}
+		if target.Description != "" {
+			input.Description = aws.String(target.Description)
+		}
+
+		arn, versionId, err := r.SyncContext.CreateSecret(input)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		return aws.StringValue(arn), aws.StringValue(versionId), "", nil
+	}
+
+	if !cr.Spec.Overwrite && !hasTag(desc.Tags, TagPushSecret, owner) {
+		return "", "", mumoshuv1alpha1.ReasonNotManaged, fmt.Errorf("secrets manager secret %s was not created by this PushSecret. Set spec.overwrite to push to it anyway", target.SecretId)
+	}
+
+	arn, versionId, err := r.SyncContext.PutSecretString(target.SecretId, value)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return aws.StringValue(arn), aws.StringValue(versionId), "", nil
+}
+
+func (r *PushSecretController) refreshInterval() time.Duration {
+	if r.RefreshInterval > 0 {
+		return r.RefreshInterval
+	}
+	return defaultRefreshInterval
+}
+
+func (r *PushSecretController) updateStatus(ctx context.Context, cr *mumoshuv1alpha1.PushSecret, mutate func(*mumoshuv1alpha1.PushSecretStatus)) error {
+	orig := cr.DeepCopy()
+
+	mutate(&cr.Status)
+
+	if reflect.DeepEqual(orig.Status, cr.Status) {
+		return nil
+	}
+
+	return r.Client.Status().Patch(ctx, cr, client.MergeFrom(orig))
+}
+
+func setPushSecretReadyCondition(cr *mumoshuv1alpha1.PushSecret, status metav1.ConditionStatus, reason, message string) func(*mumoshuv1alpha1.PushSecretStatus) {
+	return func(st *mumoshuv1alpha1.PushSecretStatus) {
+		meta.SetStatusCondition(&st.Conditions, metav1.Condition{
+			Type:               mumoshuv1alpha1.ConditionReady,
+			Status:             status,
+			ObservedGeneration: cr.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+}
+
+// pushSecretValue returns the JSON object of the keys and values to push
+func pushSecretValue(secret *corev1.Secret, keys []string) (string, error) {
+	m := map[string]string{}
+
+	if len(keys) == 0 {
+		for k, v := range secret.Data {
+			m[k] = string(v)
+		}
+	} else {
+		for _, k := range keys {
+			v, ok := secret.Data[k]
+			if !ok {
+				return "", fmt.Errorf("key %s does not exist in Secret %s", k, secret.Name)
+			}
+			m[k] = string(v)
+		}
+	}
+
+	// json.Marshal sorts map keys, so that the value and its hash are stable
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bs), nil
+}
+
+// pushSecretOwnerTagValue returns the value of the tag that marks Secrets Manager secrets created by the PushSecret
+func pushSecretOwnerTagValue(cr *mumoshuv1alpha1.PushSecret) string {
+	return cr.Namespace + "/" + cr.Name
+}
+
+func hasTag(tags []*secretsmanager.Tag, key, value string) bool {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key && aws.StringValue(t.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestPushSecretValue(t *testing.T) {
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			"tls.crt": []byte("CERT"),
+			"tls.key": []byte("KEY"),
+			"ca.crt":  []byte("CA"),
+		},
+	}
+
+	type testcase struct {
+		name    string
+		keys    []string
+		want    string
+		wantErr bool
+	}
+
+	testcases := []testcase{
+		{
+			name: "all keys",
+			want: `{"ca.crt":"CA","tls.crt":"CERT","tls.key":"KEY"}`,
+		},
+		{
+			name: "selected keys",
+			keys: []string{"tls.key", "tls.crt"},
+			want: `{"tls.crt":"CERT","tls.key":"KEY"}`,
+		},
+		{
+			name:    "missing key",
+			keys:    []string{"password"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := pushSecretValue(secret, tc.keys)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("unexpected value: want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPushSecretSharding(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	ps := &mumoshuv1alpha1.PushSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Spec: mumoshuv1alpha1.PushSecretSpec{
+			SecretRef:            corev1.LocalObjectReference{Name: "example"},
+			SecretsManagerSecret: mumoshuv1alpha1.PushSecretTarget{SecretId: "prod/mysecret"},
+		},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+
+	sm := &regionalSecretsManager{}
+
+	r := &PushSecretController{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(ps, secret).Build(),
+		Scheme:      scheme,
+		Shard:       &Shard{Identity: "replica-0", members: []string{"replica-1"}, renewed: time.Now()},
+		SyncContext: &SyncContext{sm: sm},
+	}
+
+	// The Secrets Manager client panics on any call other than GetSecretValue
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "example"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got mumoshuv1alpha1.PushSecret
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status.VersionId != "" || len(got.Status.Conditions) > 0 {
+		t.Errorf("want the pushsecret owned by another replica left alone, got status %+v", got.Status)
+	}
+}
+
+func TestPushSecretContentHash(t *testing.T) {
+	ps := func(uid string) *mumoshuv1alpha1.PushSecret {
+		return &mumoshuv1alpha1.PushSecret{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", UID: types.UID(uid)}}
+	}
+
+	value := `{"password":"secret"}`
+	hash := pushSecretContentHash([]byte("key"), ps("uid-1"), value)
+
+	if got := pushSecretContentHash([]byte("key"), ps("uid-1"), value); got != hash {
+		t.Errorf("want the same hash for the same value, got %s and %s", hash, got)
+	}
+
+	plain := sha256.Sum256([]byte(value))
+	if hash == hex.EncodeToString(plain[:]) {
+		t.Error("want the hash keyed, got the plain sha256 of the value")
+	}
+
+	if pushSecretContentHash([]byte("key"), ps("uid-2"), value) == hash {
+		t.Error("want different hashes for the same value pushed by different pushsecrets")
+	}
+
+	if pushSecretContentHash([]byte("other"), ps("uid-1"), value) == hash {
+		t.Error("want different hashes for the same value with different operator keys")
+	}
+}
@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/yaml"
+)
+
+// Quota limits the AWSSecrets of a namespace
+type Quota struct {
+	// MaxAWSSecrets is the maximum number of AWSSecrets synced in the namespace.
+	// The oldest AWSSecrets are synced, and the rest are marked with the QuotaExceeded condition. Unlimited when 0.
+	MaxAWSSecrets int `json:"maxAWSSecrets,omitempty"`
+
+	// AWSCallsPerMinute is the number of Secrets Manager API calls the AWSSecrets in the namespace can make per minute,
+	// in bursts of up to a minute's worth. Unlimited when 0.
+	AWSCallsPerMinute int `json:"awsCallsPerMinute,omitempty"`
+}
+
+// Quotas are the quotas of the namespaces, loaded from the file passed with --quota-config
+type Quotas struct {
+	// Default is the quota of the namespaces not listed in Namespaces
+	Default Quota `json:"default,omitempty"`
+
+	// Namespaces are the quotas of the namespaces, overriding Default
+	Namespaces map[string]Quota `json:"namespaces,omitempty"`
+
+	mu      sync.Mutex
+	budgets map[string]*callBudget
+	// rankings are the AWSSecrets of the namespaces ordered by the creation time, dropped by the watch when AWSSecrets come and go
+	rankings map[string]ranking
+}
+
+// LoadQuotas reads the quotas from the YAML file
+func LoadQuotas(path string) (*Quotas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var q Quotas
+	if err := yaml.UnmarshalStrict(data, &q); err != nil {
+		return nil, fmt.Errorf("parsing quota config %s: %w", path, err)
+	}
+
+	for ns, quota := range q.Namespaces {
+		if quota.MaxAWSSecrets < 0 || quota.AWSCallsPerMinute < 0 {
+			return nil, fmt.Errorf("quota of namespace %s must not be negative", ns)
+		}
+	}
+
+	if q.Default.MaxAWSSecrets < 0 || q.Default.AWSCallsPerMinute < 0 {
+		return nil, fmt.Errorf("default quota must not be negative")
+	}
+
+	return &q, nil
+}
+
+func (q *Quotas) quota(namespace string) Quota {
+	if quota, ok := q.Namespaces[namespace]; ok {
+		return quota
+	}
+	return q.Default
+}
+
+// budget returns the budget of Secrets Manager API calls of the namespace, or nil when the calls are unlimited
+func (q *Quotas) budget(namespace string) *callBudget {
+	perMinute := q.quota(namespace).AWSCallsPerMinute
+	if perMinute <= 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.budgets == nil {
+		q.budgets = map[string]*callBudget{}
+	}
+
+	b, ok := q.budgets[namespace]
+	if !ok {
+		b = newCallBudget(perMinute, time.Now())
+		q.budgets[namespace] = b
+	}
+
+	return b
+}
+
+// callBudget is a token bucket refilled with perMinute tokens per minute, holding up to perMinute tokens.
+// API calls are charged even when the bucket is empty, because a reconciliation makes several calls once started.
+// The bucket goes into debt instead, so that the namespace waits longer until its next reconciliation.
+type callBudget struct {
+	mu        sync.Mutex
+	perMinute float64
+	tokens    float64
+	last      time.Time
+}
+
+func newCallBudget(perMinute int, now time.Time) *callBudget {
+	return &callBudget{perMinute: float64(perMinute), tokens: float64(perMinute), last: now}
+}
+
+// refill adds the tokens accrued since the last refill. Must be called with the lock held.
+func (b *callBudget) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Minutes() * b.perMinute
+		b.last = now
+	}
+
+	if b.tokens > b.perMinute {
+		b.tokens = b.perMinute
+	}
+}
+
+// charge takes a token for an API call
+func (b *callBudget) charge(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens--
+}
+
+// wait returns the time until the budget has a token for an API call, or zero when it has one
+func (b *callBudget) wait(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.perMinute * float64(time.Minute))
+}
+
+// reconcileQuota reports whether the namespace of the AWSSecret is over quota in the QuotaExceeded condition,
+// and returns the delay until the AWSSecret is checked again when it is over quota, or zero when it can be synced.
+// The condition is flipped to False when the namespace is back within quota, and otherwise left absent.
+func (r *AWSSecretController) reconcileQuota(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) (time.Duration, error) {
+	if r.Quotas == nil {
+		return 0, nil
+	}
+
+	reason, message, retryAfter, err := r.quotaExceeded(ctx, cr)
+	if err != nil {
+		return 0, err
+	}
+
+	if reason != "" {
+		reqLogger.Info("Skipping the awssecret over quota", "reason", reason, "retryAfter", retryAfter)
+
+		return retryAfter, r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+			setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionQuotaExceeded, metav1.ConditionTrue, reason, message)
+		})
+	}
+
+	if !meta.IsStatusConditionTrue(cr.Status.Conditions, mumoshuv1alpha1.ConditionQuotaExceeded) {
+		return 0, nil
+	}
+
+	reqLogger.Info("Resuming the awssecret within quota")
+
+	return 0, r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionQuotaExceeded, metav1.ConditionFalse, mumoshuv1alpha1.ReasonWithinQuota, "The namespace is within quota")
+	})
+}
+
+// quotaExceeded returns the reason and the message of the QuotaExceeded condition, and the delay until the AWSSecret
+// is checked again, when the namespace of the AWSSecret is over quota. The reason is empty when it is within quota.
+func (r *AWSSecretController) quotaExceeded(ctx context.Context, cr *mumoshuv1alpha1.AWSSecret) (string, string, time.Duration, error) {
+	quota := r.Quotas.quota(cr.Namespace)
+
+	if quota.MaxAWSSecrets > 0 {
+		rank, total, err := r.Quotas.rank(ctx, r.Client, cr)
+		if err != nil {
+			return "", "", 0, err
+		}
+
+		if rank >= quota.MaxAWSSecrets {
+			message := fmt.Sprintf("Namespace %s has %d AWSSecrets, exceeding its quota of %d. Only the oldest %d are synced", cr.Namespace, total, quota.MaxAWSSecrets, quota.MaxAWSSecrets)
+			return mumoshuv1alpha1.ReasonAWSSecretCountExceeded, message, r.requeueAfter(cr), nil
+		}
+	}
+
+	if b := r.Quotas.budget(cr.Namespace); b != nil {
+		if wait := b.wait(time.Now()); wait > 0 {
+			message := fmt.Sprintf("Namespace %s has used up its budget of %d Secrets Manager API calls per minute", cr.Namespace, quota.AWSCallsPerMinute)
+			return mumoshuv1alpha1.ReasonAWSCallBudgetExceeded, message, wait, nil
+		}
+	}
+
+	return "", "", 0, nil
+}
+
+// rankKey orders AWSSecrets by the creation time and then the name
+type rankKey struct {
+	created time.Time
+	name    string
+}
+
+func rankKeyOf(cr *mumoshuv1alpha1.AWSSecret) rankKey {
+	return rankKey{created: cr.CreationTimestamp.Time, name: cr.Name}
+}
+
+func (k rankKey) before(o rankKey) bool {
+	return k.created.Before(o.created) || (k.created.Equal(o.created) && k.name < o.name)
+}
+
+// ranking is the AWSSecrets of a namespace ordered by their rankKeys. AWSSecrets being deleted are not ranked.
+type ranking []rankKey
+
+func newRanking(items []mumoshuv1alpha1.AWSSecret) ranking {
+	var r ranking
+	for i := range items {
+		if items[i].DeletionTimestamp.IsZero() {
+			r = append(r, rankKeyOf(&items[i]))
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool { return r[i].before(r[j]) })
+
+	return r
+}
+
+// find returns the number of AWSSecrets ranked before the key, and whether the key is ranked
+func (r ranking) find(k rankKey) (int, bool) {
+	i := sort.Search(len(r), func(i int) bool { return !r[i].before(k) })
+	return i, i < len(r) && r[i] == k
+}
+
+// rank returns the number of AWSSecrets created before the AWSSecret, ordered by the creation time and then the name,
+// and the total number of AWSSecrets in the namespace. AWSSecrets being deleted are not counted.
+// The AWSSecrets are listed only when the namespace has no ranking yet, or the AWSSecret is missing from it,
+// so that reconciliations don't list all the AWSSecrets in the namespace every time.
+func (q *Quotas) rank(ctx context.Context, c client.Reader, cr *mumoshuv1alpha1.AWSSecret) (int, int, error) {
+	key := rankKeyOf(cr)
+
+	q.mu.Lock()
+	r, ok := q.rankings[cr.Namespace]
+	q.mu.Unlock()
+
+	if ok {
+		if i, found := r.find(key); found || !cr.DeletionTimestamp.IsZero() {
+			return i, len(r), nil
+		}
+	}
+
+	var list mumoshuv1alpha1.AWSSecretList
+	if err := c.List(ctx, &list, client.InNamespace(cr.Namespace)); err != nil {
+		return 0, 0, err
+	}
+
+	r = newRanking(list.Items)
+
+	q.mu.Lock()
+	if q.rankings == nil {
+		q.rankings = map[string]ranking{}
+	}
+	q.rankings[cr.Namespace] = r
+	q.mu.Unlock()
+
+	i, _ := r.find(key)
+
+	return i, len(r), nil
+}
+
+// invalidate drops the ranking of the namespace, so that the AWSSecrets are listed again on the next reconciliation
+func (q *Quotas) invalidate(namespace string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.rankings, namespace)
+}
+
+var _ handler.EventHandler = &rankingInvalidator{}
+
+// rankingInvalidator drops the rankings of the namespaces where AWSSecrets are created, deleted or start being deleted
+type rankingInvalidator struct {
+	quotas *Quotas
+}
+
+func (h *rankingInvalidator) Create(e event.CreateEvent, _ workqueue.RateLimitingInterface) {
+	h.quotas.invalidate(e.Object.GetNamespace())
+}
+
+func (h *rankingInvalidator) Update(e event.UpdateEvent, _ workqueue.RateLimitingInterface) {
+	if e.ObjectOld.GetDeletionTimestamp().IsZero() != e.ObjectNew.GetDeletionTimestamp().IsZero() {
+		h.quotas.invalidate(e.ObjectNew.GetNamespace())
+	}
+}
+
+func (h *rankingInvalidator) Delete(e event.DeleteEvent, _ workqueue.RateLimitingInterface) {
+	h.quotas.invalidate(e.Object.GetNamespace())
+}
+
+func (h *rankingInvalidator) Generic(event.GenericEvent, workqueue.RateLimitingInterface) {}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestLoadQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.yaml")
+	if err := os.WriteFile(path, []byte(`
+default:
+  maxAWSSecrets: 100
+  awsCallsPerMinute: 60
+namespaces:
+  team-a:
+    maxAWSSecrets: 1000
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := LoadQuotas(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(Quota{MaxAWSSecrets: 1000}, q.quota("team-a")); diff != "" {
+		t.Errorf("unexpected quota of team-a (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(Quota{MaxAWSSecrets: 100, AWSCallsPerMinute: 60}, q.quota("team-b")); diff != "" {
+		t.Errorf("unexpected default quota (-want +got):\n%s", diff)
+	}
+
+	if q.budget("team-a") != nil {
+		t.Error("want unlimited api calls for team-a")
+	}
+
+	if b := q.budget("team-b"); b == nil || b != q.budget("team-b") {
+		t.Error("want the same budget for every reconciliation of team-b")
+	}
+
+	if err := os.WriteFile(path, []byte("default:\n  maxAWSSecret: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadQuotas(path); err == nil {
+		t.Error("want an error for the unknown field")
+	}
+}
+
+func TestCallBudget(t *testing.T) {
+	now := time.Now()
+	b := newCallBudget(60, now)
+
+	for i := 0; i < 60; i++ {
+		if wait := b.wait(now); wait != 0 {
+			t.Fatalf("want the call %d within the burst, got wait %v", i, wait)
+		}
+		b.charge(now)
+	}
+
+	// Calls in flight are charged beyond the budget
+	b.charge(now)
+
+	if wait := b.wait(now); wait != 2*time.Second {
+		t.Errorf("want to wait 2s to repay the debt, got %v", wait)
+	}
+
+	if wait := b.wait(now.Add(2 * time.Second)); wait != 0 {
+		t.Errorf("want the budget refilled, got wait %v", wait)
+	}
+
+	if wait := b.wait(now.Add(time.Hour)); wait != 0 || b.tokens != 60 {
+		t.Errorf("want the budget refilled up to a minute's worth, got %v tokens", b.tokens)
+	}
+}
+
+func TestReconcileQuota(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	created := metav1.NewTime(time.Now().Truncate(time.Second))
+
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := 0; i < 3; i++ {
+		builder = builder.WithObjects(&mumoshuv1alpha1.AWSSecret{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("example-%d", i), Namespace: "default", CreationTimestamp: metav1.NewTime(created.Add(time.Duration(i) * time.Second))},
+		})
+	}
+
+	r := &AWSSecretController{
+		Client: builder.Build(),
+		Scheme: scheme,
+		Quotas: &Quotas{Default: Quota{MaxAWSSecrets: 2, AWSCallsPerMinute: 1}},
+	}
+
+	get := func(name string) *mumoshuv1alpha1.AWSSecret {
+		var cr mumoshuv1alpha1.AWSSecret
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &cr); err != nil {
+			t.Fatal(err)
+		}
+		return &cr
+	}
+
+	// The newest AWSSecret is over the count quota
+	newest := get("example-2")
+	if retryAfter, err := r.reconcileQuota(ctx, logr.Discard(), newest); err != nil || retryAfter == 0 {
+		t.Fatalf("want the newest awssecret skipped, got %v, %v", retryAfter, err)
+	}
+
+	if c := meta.FindStatusCondition(get("example-2").Status.Conditions, mumoshuv1alpha1.ConditionQuotaExceeded); c == nil || c.Status != metav1.ConditionTrue || c.Reason != mumoshuv1alpha1.ReasonAWSSecretCountExceeded {
+		t.Errorf("unexpected condition: %+v", c)
+	}
+
+	// The oldest AWSSecret is within the count quota, and uses up the budget
+	if retryAfter, err := r.reconcileQuota(ctx, logr.Discard(), get("example-0")); err != nil || retryAfter != 0 {
+		t.Fatalf("want the oldest awssecret synced, got %v, %v", retryAfter, err)
+	}
+
+	r.Quotas.budget("default").charge(time.Now())
+
+	oldest := get("example-0")
+	if retryAfter, err := r.reconcileQuota(ctx, logr.Discard(), oldest); err != nil || retryAfter == 0 {
+		t.Fatalf("want the awssecret skipped over budget, got %v, %v", retryAfter, err)
+	}
+
+	if c := meta.FindStatusCondition(get("example-0").Status.Conditions, mumoshuv1alpha1.ConditionQuotaExceeded); c == nil || c.Reason != mumoshuv1alpha1.ReasonAWSCallBudgetExceeded {
+		t.Errorf("unexpected condition: %+v", c)
+	}
+
+	// Deleting an older AWSSecret brings the newest one within quota, once the watch drops the ranking
+	deleted := get("example-1")
+	if err := r.Client.Delete(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	(&rankingInvalidator{quotas: r.Quotas}).Delete(event.DeleteEvent{Object: deleted}, nil)
+
+	r.Quotas.Default.AWSCallsPerMinute = 0
+
+	if retryAfter, err := r.reconcileQuota(ctx, logr.Discard(), get("example-2")); err != nil || retryAfter != 0 {
+		t.Fatalf("want the newest awssecret synced, got %v, %v", retryAfter, err)
+	}
+
+	if c := meta.FindStatusCondition(get("example-2").Status.Conditions, mumoshuv1alpha1.ConditionQuotaExceeded); c == nil || c.Status != metav1.ConditionFalse || c.Reason != mumoshuv1alpha1.ReasonWithinQuota {
+		t.Errorf("unexpected condition: %+v", c)
+	}
+}
+
+// listCountingReader counts the lists
+type listCountingReader struct {
+	client.Reader
+	lists int
+}
+
+func (r *listCountingReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	r.lists++
+	return r.Reader.List(ctx, list, opts...)
+}
+
+func TestQuotaRanking(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	created := metav1.NewTime(time.Now().Truncate(time.Second))
+
+	var items []*mumoshuv1alpha1.AWSSecret
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := 0; i < 3; i++ {
+		cr := &mumoshuv1alpha1.AWSSecret{
+			// Created at the same time, ranked by the name
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("example-%d", i), Namespace: "default", CreationTimestamp: created},
+		}
+		items = append(items, cr)
+		builder = builder.WithObjects(cr)
+	}
+
+	c := &listCountingReader{Reader: builder.Build()}
+	q := &Quotas{}
+
+	for i, cr := range items {
+		rank, total, err := q.rank(ctx, c, cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rank != i || total != 3 {
+			t.Errorf("%s: want rank %d of 3, got %d of %d", cr.Name, i, rank, total)
+		}
+	}
+
+	if c.lists != 1 {
+		t.Errorf("want the awssecrets listed once for all the reconciliations, got %d lists", c.lists)
+	}
+
+	// An AWSSecret missing from the ranking, like one created before the watch dropped the ranking, is ranked after listing again
+	added := &mumoshuv1alpha1.AWSSecret{ObjectMeta: metav1.ObjectMeta{Name: "example-3", Namespace: "default", CreationTimestamp: created}}
+	if err := c.Reader.(client.Client).Create(ctx, added); err != nil {
+		t.Fatal(err)
+	}
+
+	if rank, total, err := q.rank(ctx, c, added); err != nil || rank != 3 || total != 4 {
+		t.Errorf("want rank 3 of 4, got %d of %d, %v", rank, total, err)
+	}
+
+	if c.lists != 2 {
+		t.Errorf("want the awssecrets listed again, got %d lists", c.lists)
+	}
+}
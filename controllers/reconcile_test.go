@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeSecretsManager serves the secret `{"value":"<secret id>"}` at version v1 for every secret id
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+}
+
+func (f *fakeSecretsManager) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	return &secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String(fmt.Sprintf(`{"value":%q}`, aws.StringValue(input.SecretId))),
+		VersionId:    aws.String("v1"),
+	}, nil
+}
+
+func (f *fakeSecretsManager) ListSecretVersionIdsPages(_ *secretsmanager.ListSecretVersionIdsInput, fn func(*secretsmanager.ListSecretVersionIdsOutput, bool) bool) error {
+	fn(&secretsmanager.ListSecretVersionIdsOutput{
+		Versions: []*secretsmanager.SecretVersionsListEntry{{VersionId: aws.String("v1"), VersionStages: aws.StringSlice([]string{stageCurrent})}},
+	}, true)
+
+	return nil
+}
+
+// applyingClient emulates server-side apply, which the fake client doesn't support, with create and update
+type applyingClient struct {
+	client.Client
+}
+
+func (c *applyingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	existing := obj.DeepCopyObject().(client.Object)
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return c.Client.Create(ctx, obj)
+	}
+
+	obj.SetResourceVersion(existing.GetResourceVersion())
+
+	return c.Client.Update(ctx, obj)
+}
+
+func TestConcurrentReconciles(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	const n = 20
+
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := 0; i < n; i++ {
+		builder = builder.WithObjects(&mumoshuv1alpha1.AWSSecret{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("example-%d", i), Namespace: "default", UID: types.UID(fmt.Sprintf("uid-%d", i))},
+			Spec: mumoshuv1alpha1.AWSSecretSpec{
+				StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+					SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: fmt.Sprintf("prod/secret-%d", i), VersionId: "v1"},
+				},
+			},
+		})
+	}
+
+	r := &AWSSecretController{
+		Client:      &applyingClient{Client: builder.Build()},
+		Scheme:      scheme,
+		SyncContext: &SyncContext{sm: &fakeSecretsManager{}},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n*2)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("example-%d", i)}}
+
+			// The second reconciliation updates the existing Secret
+			for j := 0; j < 2; j++ {
+				if _, err := r.Reconcile(ctx, req); err != nil {
+					errs <- fmt.Errorf("reconciling %s: %w", req.Name, err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for i := 0; i < n; i++ {
+		var secret corev1.Secret
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("example-%d", i)}, &secret); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := fmt.Sprintf("prod/secret-%d", i), string(secret.Data["value"]); want != got {
+			t.Errorf("unexpected value of example-%d: want %s, got %s", i, want, got)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podSpecReferencesSecret returns true when the pod spec consumes the named Secret
+// via volumes, projected volumes, env, envFrom or image pull secrets.
+func podSpecReferencesSecret(spec *corev1.PodSpec, name string) bool {
+	for _, v := range spec.Volumes {
+		if v.Secret != nil && v.Secret.SecretName == name {
+			return true
+		}
+
+		if v.Projected != nil {
+			for _, s := range v.Projected.Sources {
+				if s.Secret != nil && s.Secret.Name == name {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, s := range spec.ImagePullSecrets {
+		if s.Name == name {
+			return true
+		}
+	}
+
+	var containers []corev1.Container
+	containers = append(containers, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+
+	for _, c := range containers {
+		for _, e := range c.EnvFrom {
+			if e.SecretRef != nil && e.SecretRef.Name == name {
+				return true
+			}
+		}
+
+		for _, e := range c.Env {
+			if e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil && e.ValueFrom.SecretKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
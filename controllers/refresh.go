@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultRefreshInterval is the interval of the periodic resync when RefreshInterval is omitted
+const defaultRefreshInterval = 5 * time.Minute
+
+func (r *AWSSecretController) refreshInterval() time.Duration {
+	if r.RefreshInterval > 0 {
+		return r.RefreshInterval
+	}
+	return defaultRefreshInterval
+}
+
+// requeueAfter returns the delay until the next periodic resync of the AWSSecret
+func (r *AWSSecretController) requeueAfter(cr *mumoshuv1alpha1.AWSSecret) time.Duration {
+	return jitteredRefresh(cr, r.refreshInterval(), time.Now())
+}
+
+// jitteredRefresh returns the delay until the next resync slot of the object.
+// Each object is resynced at a fixed offset within every refresh interval, derived from its UID,
+// so that the objects created together are resynced at different times instead of in lockstep.
+// The delay is at least half the interval, so that an object synced just before its slot skips that slot.
+func jitteredRefresh(obj metav1.Object, interval time.Duration, now time.Time) time.Duration {
+	key := string(obj.GetUID())
+	if key == "" {
+		key = obj.GetNamespace() + "/" + obj.GetName()
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	offset := time.Duration(h.Sum64() % uint64(interval))
+
+	// The time until the next slot, in the range (0, interval]
+	delay := interval - (time.Duration(now.UnixNano())-offset)%interval
+	if delay < interval/2 {
+		delay += interval
+	}
+
+	return delay
+}
+
+// sourceRef is a Secrets Manager secret the AWSSecret syncs, named after the spec field referencing it
+type sourceRef struct {
+	name string
+	ref  mumoshuv1alpha1.SecretsManagerSecretRef
+}
+
+// activeSources returns the sources the AWSSecret syncs
+func activeSources(cr *mumoshuv1alpha1.AWSSecret) []sourceRef {
+	var sources []sourceRef
+
+	for _, s := range []sourceRef{
+		{name: "stringDataFrom", ref: cr.Spec.StringDataFrom.SecretsManagerSecretRef},
+		{name: "dataFrom", ref: cr.Spec.DataFrom.SecretsManagerSecretRef},
+	} {
+		if s.ref.SecretId != "" && (s.ref.VersionId != "" || s.ref.VersionStage != "" || s.ref.Generate != nil) {
+			sources = append(sources, s)
+		}
+	}
+
+	return sources
+}
+
+// followedStage returns the staging label the ref follows, or an empty string when it is pinned to a VersionId.
+// Generated secrets without a VersionId follow AWSCURRENT.
+func followedStage(ref mumoshuv1alpha1.SecretsManagerSecretRef) string {
+	switch {
+	case ref.VersionId != "":
+		return ""
+	case ref.VersionStage != "":
+		return ref.VersionStage
+	}
+	return stageCurrent
+}
+
+// resolveSourceVersions returns the VersionIds of the source versions to sync, keyed by the source name,
+// and by the source name suffixed with the stage for the additional versions emitted as keys.
+// DescribeSecret is called only for the sources that follow a staging label or emit additional versions,
+// so that pinned sources are resolved without calling AWS.
+func (r *AWSSecretController) resolveSourceVersions(cr *mumoshuv1alpha1.AWSSecret) (map[string]string, error) {
+	versions := map[string]string{}
+	described := map[string]*secretsmanager.DescribeSecretOutput{}
+	additional := additionalStageLabels(cr, mumoshuv1alpha1.AdditionalStagesOutputKeys)
+
+	for _, s := range activeSources(cr) {
+		stage := followedStage(s.ref)
+
+		if stage == "" && len(additional) == 0 {
+			versions[s.name] = s.ref.VersionId
+			continue
+		}
+
+		desc, ok := described[s.ref.SecretId]
+		if !ok {
+			var err error
+			desc, err = r.SyncContext.DescribeSecretWithFailover(s.ref.SecretId, r.fallbackRegions(cr))
+			if err != nil {
+				return nil, err
+			}
+			if desc == nil {
+				return nil, fmt.Errorf("secrets manager secret %s does not exist", s.ref.SecretId)
+			}
+			described[s.ref.SecretId] = desc
+		}
+
+		if stage == "" {
+			versions[s.name] = s.ref.VersionId
+		} else if v := versionWithStage(desc.VersionIdsToStages, stage); v != "" {
+			versions[s.name] = v
+		} else {
+			return nil, fmt.Errorf("no version of secrets manager secret %s is labelled %s", s.ref.SecretId, stage)
+		}
+
+		for _, st := range additional {
+			if v := versionWithStage(desc.VersionIdsToStages, string(st)); v != "" {
+				versions[s.name+"_"+stageSuffix(st)] = v
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+// versionWithStage returns the VersionId of the version labelled with the stage, or an empty string when there is none
+func versionWithStage(stages map[string][]*string, stage string) string {
+	for v, labels := range stages {
+		for _, l := range labels {
+			if aws.StringValue(l) == stage {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// formatSourceVersions returns the value of the source-versions annotation for the source versions
+func formatSourceVersions(versions map[string]string) string {
+	pairs := make([]string, 0, len(versions))
+	for k, v := range versions {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// reusableData returns the data the operator synced into the live Secret, when the live Secret was synced from
+// the same source versions and its data hasn't been modified since. It returns nil when the data needs to be fetched from Secrets Manager.
+func reusableData(live *corev1.Secret, sourceVersions string) map[string][]byte {
+	if live == nil || sourceVersions == "" || live.Annotations[AnnotationSourceVersions] != sourceVersions {
+		return nil
+	}
+
+	data := syncedData(live)
+	if data == nil || live.Annotations[AnnotationDataHash] != dataHash(data) {
+		return nil
+	}
+
+	return data
+}
+
+// dataModified returns true when the data the operator synced into the live Secret no longer matches the hash recorded on it
+func dataModified(live *corev1.Secret) bool {
+	hash, ok := live.Annotations[AnnotationDataHash]
+	if !ok {
+		return false
+	}
+
+	data := syncedData(live)
+
+	return data != nil && dataHash(data) != hash
+}
+
+// syncedData returns the data of the keys the operator owns in the live Secret, or nil when it is unknown
+func syncedData(live *corev1.Secret) map[string][]byte {
+	owned := ownedDataKeys(live)
+
+	data := make(map[string][]byte, len(owned))
+	for _, k := range owned {
+		if v, ok := live.Data[k]; ok {
+			data[k] = v
+		}
+	}
+
+	// Secrets written before the operator used server-side apply have no record of the keys we own
+	if _, ok := data[versionIdKeyPrefix]; !ok {
+		return nil
+	}
+
+	return data
+}
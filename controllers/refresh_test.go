@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReusableData(t *testing.T) {
+	owned := []metav1.ManagedFieldsEntry{
+		{
+			Manager:   FieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{},"f:AWSVersionId":{},"f:foo":{}}}`)},
+		},
+	}
+
+	sourceVersions := formatSourceVersions(map[string]string{"stringDataFrom": "v2", "dataFrom": "v1"})
+	if sourceVersions != "dataFrom=v1,stringDataFrom=v2" {
+		t.Fatalf("unexpected source versions: %s", sourceVersions)
+	}
+
+	hash := dataHash(map[string][]byte{"AWSVersionId": []byte("v2"), "foo": []byte("FOO")})
+
+	live := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations:   map[string]string{AnnotationSourceVersions: sourceVersions, AnnotationDataHash: hash},
+			ManagedFields: owned,
+		},
+		Data: map[string][]byte{"AWSVersionId": []byte("v2"), "foo": []byte("FOO"), "added-by-others": []byte("x")},
+	}
+
+	modified := live.DeepCopy()
+	modified.Data["foo"] = []byte("BAR")
+
+	unhashed := live.DeepCopy()
+	delete(unhashed.Annotations, AnnotationDataHash)
+
+	type testcase struct {
+		name           string
+		live           *corev1.Secret
+		sourceVersions string
+		want           map[string][]byte
+	}
+
+	testcases := []testcase{
+		{
+			name:           "unchanged versions reuse our keys only",
+			live:           live,
+			sourceVersions: sourceVersions,
+			want:           map[string][]byte{"AWSVersionId": []byte("v2"), "foo": []byte("FOO")},
+		},
+		{
+			name:           "changed versions",
+			live:           live,
+			sourceVersions: "dataFrom=v1,stringDataFrom=v3",
+		},
+		{
+			name:           "no live secret",
+			sourceVersions: sourceVersions,
+		},
+		{
+			name: "no record of owned keys",
+			live: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AnnotationSourceVersions: sourceVersions}},
+				Data:       live.Data,
+			},
+			sourceVersions: sourceVersions,
+		},
+		{
+			name:           "data modified since synced",
+			live:           modified,
+			sourceVersions: sourceVersions,
+		},
+		{
+			name:           "no record of the data hash",
+			live:           unhashed,
+			sourceVersions: sourceVersions,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := reusableData(tc.live, tc.sourceVersions)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected data (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDataModified(t *testing.T) {
+	owned := []metav1.ManagedFieldsEntry{
+		{
+			Manager:   FieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{},"f:AWSVersionId":{},"f:foo":{}}}`)},
+		},
+	}
+
+	synced := map[string][]byte{"AWSVersionId": []byte("v1"), "foo": []byte("FOO")}
+
+	secret := func(foo string, annotations map[string]string, managedFields []metav1.ManagedFieldsEntry) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations, ManagedFields: managedFields},
+			Data:       map[string][]byte{"AWSVersionId": []byte("v1"), "foo": []byte(foo), "added-by-others": []byte("x")},
+		}
+	}
+
+	hashed := map[string]string{AnnotationDataHash: dataHash(synced)}
+
+	testcases := []struct {
+		name string
+		live *corev1.Secret
+		want bool
+	}{
+		{name: "unmodified", live: secret("FOO", hashed, owned)},
+		{name: "modified", live: secret("BAR", hashed, owned), want: true},
+		{name: "no record of the data hash", live: secret("BAR", nil, owned)},
+		{name: "no record of owned keys", live: secret("BAR", hashed, nil)},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dataModified(tc.live); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestJitteredRefresh(t *testing.T) {
+	const interval = 5 * time.Minute
+
+	now := time.Now()
+
+	// The resync slots of the objects created together, in tenths of the interval
+	slots := map[int]int{}
+
+	for i := 0; i < 1000; i++ {
+		obj := &metav1.ObjectMeta{Namespace: "default", Name: fmt.Sprintf("example-%d", i), UID: types.UID(fmt.Sprintf("uid-%d", i))}
+
+		delay := jitteredRefresh(obj, interval, now)
+		if delay < interval/2 || delay >= interval*3/2 {
+			t.Fatalf("delay out of range: %v", delay)
+		}
+
+		// The slot is fixed, so that the object keeps its place after every resync
+		if next := jitteredRefresh(obj, interval, now.Add(delay)); next != interval {
+			t.Errorf("want the next resync after exactly the interval, got %v", next)
+		}
+
+		slots[int(now.Add(delay).UnixNano()%int64(interval)*10/int64(interval))]++
+	}
+
+	for slot := 0; slot < 10; slot++ {
+		if n := slots[slot]; n < 60 || n > 140 {
+			t.Errorf("unevenly spread resyncs: %v", slots)
+			break
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+)
+
+const (
+	// circuitBreakerThreshold is the number of consecutive regional errors that opens the circuit breaker of a region
+	circuitBreakerThreshold = 5
+
+	// circuitBreakerCooldown is how long the circuit breaker of a region stays open before a probe call is let through
+	circuitBreakerCooldown = 30 * time.Second
+)
+
+// errCircuitOpen is returned for a region whose circuit breaker is open
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// regionalClients holds the Secrets Manager clients of the fallback regions and the circuit breakers of all the regions.
+// It is shared by all the reconciliations, and safe for concurrent use.
+type regionalClients struct {
+	// primary is the region of the client of the SyncContext
+	primary string
+
+	// newClient returns the client for the fallback region
+	newClient func(region string) secretsmanageriface.SecretsManagerAPI
+
+	mu       sync.Mutex
+	clients  map[string]secretsmanageriface.SecretsManagerAPI
+	breakers map[string]*circuitBreaker
+}
+
+func newRegionalClients(primary string, newClient func(string) secretsmanageriface.SecretsManagerAPI) *regionalClients {
+	return &regionalClients{
+		primary:   primary,
+		newClient: newClient,
+		clients:   map[string]secretsmanageriface.SecretsManagerAPI{},
+		breakers:  map[string]*circuitBreaker{},
+	}
+}
+
+// client returns the client of the fallback region, creating it on first use
+func (r *regionalClients) client(region string) secretsmanageriface.SecretsManagerAPI {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.clients[region]
+	if !ok {
+		c = r.newClient(region)
+		r.clients[region] = c
+	}
+
+	return c
+}
+
+func (r *regionalClients) breaker(region string) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[region]
+	if !ok {
+		b = &circuitBreaker{region: region}
+		r.breakers[region] = b
+	}
+
+	return b
+}
+
+// failover calls fn with the client of the primary region, and then with the clients of the fallback regions in order,
+// while the calls fail with regional errors or the circuit breakers of the regions are open.
+// The secret id is rewritten for the region when it is an ARN. It returns the region that served the call.
+func (c *SyncContext) failover(secretId string, fallbackRegions []string, fn func(sm secretsmanageriface.SecretsManagerAPI, secretId string) error) (string, error) {
+	if c.regions == nil {
+		return "", fn(c.client(), secretId)
+	}
+
+	var lastErr error
+
+	for i, region := range append([]string{c.regions.primary}, fallbackRegions...) {
+		if i > 0 && region == c.regions.primary {
+			continue
+		}
+
+		b := c.regions.breaker(region)
+		if !b.allow(time.Now()) {
+			lastErr = errCircuitOpen
+			continue
+		}
+
+		var sm secretsmanageriface.SecretsManagerAPI
+		if i == 0 {
+			sm = c.client()
+		} else {
+			c.charge()
+			sm = c.regions.client(region)
+		}
+
+		err := fn(sm, secretIdInRegion(secretId, region))
+		if err != nil && isRegionalError(err) {
+			b.failure(time.Now())
+			lastErr = err
+			continue
+		}
+
+		// Errors like missing secrets and permissions mean the regional endpoint is healthy
+		b.success()
+
+		if i > 0 && err == nil {
+			regionFailovers.WithLabelValues(region).Inc()
+		}
+
+		return region, err
+	}
+
+	return "", lastErr
+}
+
+// isFallbackRegion returns true when the region is not the primary region
+func (c *SyncContext) isFallbackRegion(region string) bool {
+	return c.regions != nil && region != "" && region != c.regions.primary
+}
+
+// fallbackRegions returns the fallback regions of the AWSSecret, defaulting to the ones of the controller
+func (r *AWSSecretController) fallbackRegions(cr *mumoshuv1alpha1.AWSSecret) []string {
+	if len(cr.Spec.FallbackRegions) > 0 {
+		return cr.Spec.FallbackRegions
+	}
+	return r.FallbackRegions
+}
+
+// secretIdInRegion returns the ARN of the replica in the region when the secret id is an ARN, and the secret id as is otherwise,
+// because replicas share the name of the primary secret
+func secretIdInRegion(secretId, region string) string {
+	a, err := arn.Parse(secretId)
+	if err != nil || region == "" {
+		return secretId
+	}
+
+	a.Region = region
+
+	return a.String()
+}
+
+// isRegionalError returns true for errors that suggest the regional endpoint is unavailable, like connection errors,
+// timeouts and server errors, as opposed to errors like missing secrets, permissions and throttling
+func isRegionalError(err error) bool {
+	if errors.Is(err, errCircuitOpen) {
+		return true
+	}
+
+	var failure awserr.RequestFailure
+	if errors.As(err, &failure) && failure.StatusCode() >= 500 {
+		return true
+	}
+
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	switch aerr.Code() {
+	case request.ErrCodeRequestError, request.ErrCodeResponseTimeout, "RequestTimeout", secretsmanager.ErrCodeInternalServiceError:
+		return true
+	}
+
+	return false
+}
+
+// isTransientError returns true for errors that are likely to go away when retried, like regional errors and throttling
+func isTransientError(err error) bool {
+	if isRegionalError(err) {
+		return true
+	}
+
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && (request.IsErrorThrottle(aerr) || request.IsErrorRetryable(aerr))
+}
+
+// circuitBreaker stops calling a region after consecutive regional errors. Once the cooldown has passed,
+// it lets one probe call through at a time, and closes when a probe succeeds.
+type circuitBreaker struct {
+	region string
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns true when a call to the region can be made
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < circuitBreakerThreshold {
+		return true
+	}
+
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+
+	regionCircuitOpen.WithLabelValues(b.region).Set(0)
+}
+
+func (b *circuitBreaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.failures >= circuitBreakerThreshold {
+		b.openUntil = now.Add(circuitBreakerCooldown)
+		regionCircuitOpen.WithLabelValues(b.region).Set(1)
+	}
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// regionalSecretsManager serves the secret `{"region":"<region>"}` at version v1, or fails with err
+type regionalSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	region    string
+	err       error
+	calls     int
+	secretIds []string
+}
+
+func (f *regionalSecretsManager) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	f.calls++
+	f.secretIds = append(f.secretIds, aws.StringValue(input.SecretId))
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String(`{"region":"` + f.region + `"}`),
+		VersionId:    aws.String("v1"),
+	}, nil
+}
+
+func (f *regionalSecretsManager) ListSecretVersionIdsPages(_ *secretsmanager.ListSecretVersionIdsInput, _ func(*secretsmanager.ListSecretVersionIdsOutput, bool) bool) error {
+	return f.err
+}
+
+func newRegionalSyncContext(primary *regionalSecretsManager, fallbacks ...*regionalSecretsManager) *SyncContext {
+	byRegion := map[string]secretsmanageriface.SecretsManagerAPI{}
+	for _, f := range fallbacks {
+		byRegion[f.region] = f
+	}
+
+	return &SyncContext{
+		sm: primary,
+		regions: newRegionalClients(primary.region, func(region string) secretsmanageriface.SecretsManagerAPI {
+			return byRegion[region]
+		}),
+	}
+}
+
+func TestFailover(t *testing.T) {
+	unavailable := awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("dial tcp: i/o timeout"))
+
+	primary := &regionalSecretsManager{region: "us-east-1", err: unavailable}
+	fallback := &regionalSecretsManager{region: "us-west-2"}
+	c := newRegionalSyncContext(primary, fallback)
+
+	arn := "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/mysecret-AbCdEf"
+
+	sec, _, region, err := c.StringWithFailover(arn, "v1", []string{"us-west-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if region != "us-west-2" || aws.StringValue(sec) != `{"region":"us-west-2"}` {
+		t.Errorf("want the value served by the fallback region, got %s from %q", aws.StringValue(sec), region)
+	}
+
+	if want := "arn:aws:secretsmanager:us-west-2:123456789012:secret:prod/mysecret-AbCdEf"; fallback.secretIds[0] != want {
+		t.Errorf("want the arn of the replica %s, got %s", want, fallback.secretIds[0])
+	}
+
+	// The circuit breaker opens after consecutive regional errors, and the primary region is no longer called
+	for i := 1; i < circuitBreakerThreshold+3; i++ {
+		if _, _, _, err := c.StringWithFailover("prod/mysecret", "v1", []string{"us-west-2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if primary.calls != circuitBreakerThreshold {
+		t.Errorf("want %d calls to the unhealthy primary region, got %d", circuitBreakerThreshold, primary.calls)
+	}
+
+	// A probe is let through after the cooldown, and closes the circuit breaker when it succeeds
+	b := c.regions.breaker("us-east-1")
+	if !b.allow(time.Now().Add(circuitBreakerCooldown)) {
+		t.Fatal("want a probe let through after the cooldown")
+	}
+	if b.allow(time.Now().Add(circuitBreakerCooldown)) {
+		t.Fatal("want only one probe at a time")
+	}
+
+	b.success()
+	primary.err = nil
+
+	if _, _, region, err := c.StringWithFailover("prod/mysecret", "v1", []string{"us-west-2"}); err != nil || region != "us-east-1" {
+		t.Errorf("want the value served by the recovered primary region, got %q, %v", region, err)
+	}
+}
+
+func TestFailoverNonRegionalError(t *testing.T) {
+	notFound := awserr.NewRequestFailure(awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil), 400, "")
+
+	primary := &regionalSecretsManager{region: "us-east-1", err: notFound}
+	fallback := &regionalSecretsManager{region: "us-west-2"}
+	c := newRegionalSyncContext(primary, fallback)
+
+	if _, _, _, err := c.StringWithFailover("prod/mysecret", "v1", []string{"us-west-2"}); !isResourceNotFound(err) {
+		t.Errorf("want the error of the primary region, got %v", err)
+	}
+
+	if fallback.calls != 0 {
+		t.Errorf("want no failover on errors other than regional ones, got %d calls", fallback.calls)
+	}
+}
+
+func TestIsRegionalError(t *testing.T) {
+	testcases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection error", err: awserr.New(request.ErrCodeRequestError, "send request failed", nil), want: true},
+		{name: "server error", err: awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "unavailable", nil), 503, ""), want: true},
+		{name: "internal service error", err: awserr.NewRequestFailure(awserr.New(secretsmanager.ErrCodeInternalServiceError, "internal", nil), 500, ""), want: true},
+		{name: "circuit open", err: errCircuitOpen, want: true},
+		{name: "throttling", err: awserr.NewRequestFailure(awserr.New("ThrottlingException", "rate exceeded", nil), 400, "")},
+		{name: "access denied", err: awserr.NewRequestFailure(awserr.New("AccessDeniedException", "denied", nil), 400, "")},
+		{name: "other", err: errors.New("other")},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRegionalError(tc.err); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestServedRegion(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", UID: "uid"},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret", VersionId: "v1"},
+			},
+			FallbackRegions: []string{"us-west-2"},
+		},
+	}
+
+	primary := &regionalSecretsManager{region: "us-east-1", err: awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "unavailable", nil), 503, "")}
+
+	r := &AWSSecretController{
+		Client:      &applyingClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build()},
+		Scheme:      scheme,
+		SyncContext: newRegionalSyncContext(primary, &regionalSecretsManager{region: "us-west-2"}),
+	}
+
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "example"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got mumoshuv1alpha1.AWSSecret
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status.ServedRegion != "us-west-2" {
+		t.Errorf("want the served region us-west-2, got %q", got.Status.ServedRegion)
+	}
+}
+
+func TestStageDataFailover(t *testing.T) {
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret"},
+			},
+			AdditionalStages: &mumoshuv1alpha1.AdditionalStages{},
+		},
+	}
+
+	primary := &regionalSecretsManager{region: "us-east-1", err: awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "unavailable", nil), 503, "")}
+
+	r := &AWSSecretController{
+		SyncContext:     newRegionalSyncContext(primary, &regionalSecretsManager{region: "us-west-2"}),
+		FallbackRegions: []string{"us-west-2"},
+	}
+
+	stringData := map[string]string{}
+	if err := r.addStageKeys(cr, stringData, map[string][]byte{}); err != nil {
+		t.Fatalf("want the additional stages read from the fallback region, got %v", err)
+	}
+
+	if got := stringData["region_previous"]; got != "us-west-2" {
+		t.Errorf("want the previous version served by us-west-2, got %q", got)
+	}
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	errs "github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileRestarts restarts the workloads consuming the Secret when its content differs from the one they were last restarted for,
+// and returns the content hash to record in the status.
+// The content the workloads were restarted for is unknown until recorded, in which case they are restarted only when the versions have changed.
+func (r *AWSSecretController) reconcileRestarts(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret, desired *corev1.Secret, versionChanged bool) (string, error) {
+	if !cr.Spec.RestartWorkloads {
+		return "", nil
+	}
+
+	hash := secretDataHash(desired)
+	restarted := cr.Status.RestartedHash
+
+	if restarted == hash || (restarted == "" && !versionChanged) {
+		return hash, nil
+	}
+
+	if err := r.restartWorkloads(ctx, reqLogger, cr, desired.Name, hash); err != nil {
+		return restarted, errs.Wrap(err, "failed to restart workloads")
+	}
+
+	return hash, nil
+}
+
+// restartWorkloads triggers rolling restarts of the Deployments, StatefulSets and DaemonSets in the namespace
+// that reference the Secret or opted in via the restart-on-change annotation, by setting a pod template annotation
+// to the content hash of the Secret.
+// The workloads are listed from the apiserver, so that the cache doesn't hold every workload in the watched namespaces.
+func (r *AWSSecretController) restartWorkloads(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret, secretName, hash string) error {
+	key := checksumAnnotationKey(cr.Name)
+
+	var deployments appsv1.DeploymentList
+	if err := r.apiReader().List(ctx, &deployments, client.InNamespace(cr.Namespace)); err != nil {
+		return err
+	}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		if !workloadConsumesSecret(d.Annotations, &d.Spec.Template.Spec, cr.Name, secretName) {
+			continue
+		}
+		if err := r.patchPodTemplateAnnotation(ctx, reqLogger, "Deployment", d, &d.Spec.Template, key, hash); err != nil {
+			return err
+		}
+	}
+
+	var statefulSets appsv1.StatefulSetList
+	if err := r.apiReader().List(ctx, &statefulSets, client.InNamespace(cr.Namespace)); err != nil {
+		return err
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		if !workloadConsumesSecret(s.Annotations, &s.Spec.Template.Spec, cr.Name, secretName) {
+			continue
+		}
+		if err := r.patchPodTemplateAnnotation(ctx, reqLogger, "StatefulSet", s, &s.Spec.Template, key, hash); err != nil {
+			return err
+		}
+	}
+
+	var daemonSets appsv1.DaemonSetList
+	if err := r.apiReader().List(ctx, &daemonSets, client.InNamespace(cr.Namespace)); err != nil {
+		return err
+	}
+	for i := range daemonSets.Items {
+		d := &daemonSets.Items[i]
+		if !workloadConsumesSecret(d.Annotations, &d.Spec.Template.Spec, cr.Name, secretName) {
+			continue
+		}
+		if err := r.patchPodTemplateAnnotation(ctx, reqLogger, "DaemonSet", d, &d.Spec.Template, key, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *AWSSecretController) patchPodTemplateAnnotation(ctx context.Context, reqLogger logr.Logger, kind string, obj client.Object, tmpl *corev1.PodTemplateSpec, key, hash string) error {
+	if tmpl.Annotations[key] == hash {
+		return nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+
+	if tmpl.Annotations == nil {
+		tmpl.Annotations = map[string]string{}
+	}
+	tmpl.Annotations[key] = hash
+
+	reqLogger.Info("Restarting workload", "kind", kind, "Workload.Name", obj.GetName())
+
+	return r.Client.Patch(ctx, obj, patch)
+}
+
+// workloadConsumesSecret returns true when the workload either references the Secret from its pod template
+// or lists the AWSSecret in its restart-on-change annotation.
+func workloadConsumesSecret(annotations map[string]string, spec *corev1.PodSpec, awsSecretName, secretName string) bool {
+	for _, n := range strings.Split(annotations[AnnotationRestartOnChange], ",") {
+		if strings.TrimSpace(n) == awsSecretName {
+			return true
+		}
+	}
+
+	return podSpecReferencesSecret(spec, secretName)
+}
+
+// checksumAnnotationKey returns the pod template annotation key for the AWSSecret.
+// The name part of an annotation key is limited to 63 characters, so longer names are truncated and suffixed with a hash.
+func checksumAnnotationKey(awsSecretName string) string {
+	return checksumAnnotationPrefix + truncatedName(awsSecretName)
+}
+
+// truncatedName returns the name as is when it fits in a label value or the name part of an annotation key,
+// or truncated and suffixed with a hash of the whole name otherwise
+func truncatedName(name string) string {
+	return truncateName(name, validation.LabelValueMaxLength)
+}
+
+// truncateName returns the name as is when it is at most max characters long,
+// or truncated to max characters including a hash of the whole name otherwise
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:8]
+
+	return strings.TrimRight(name[:max-len(suffix)-1], "-.") + "-" + suffix
+}
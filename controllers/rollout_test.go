@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestWorkloadConsumesSecret(t *testing.T) {
+	type testcase struct {
+		name        string
+		annotations map[string]string
+		spec        corev1.PodSpec
+		want        bool
+	}
+
+	testcases := []testcase{
+		{
+			name:        "opted in via annotation",
+			annotations: map[string]string{AnnotationRestartOnChange: "other, example"},
+			want:        true,
+		},
+		{
+			name: "references the secret",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "example"}}},
+			}},
+			want: true,
+		},
+		{
+			name:        "unrelated",
+			annotations: map[string]string{AnnotationRestartOnChange: "other"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := workloadConsumesSecret(tc.annotations, &tc.spec, "example", "example"); got != tc.want {
+				t.Errorf("unexpected result: want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestChecksumAnnotationKey(t *testing.T) {
+	if got, want := checksumAnnotationKey("example"), "checksum.aws-secret-operator.mumoshu.github.io/example"; got != want {
+		t.Errorf("unexpected key: want %s, got %s", want, got)
+	}
+
+	long := strings.Repeat("a", 100)
+
+	key := checksumAnnotationKey(long)
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		t.Errorf("invalid key %s: %v", key, errs)
+	}
+
+	if key == checksumAnnotationKey(strings.Repeat("a", 101)) {
+		t.Errorf("truncated keys must not collide")
+	}
+}
+
+// failingListClient fails to list objects while err is set
+type failingListClient struct {
+	client.Client
+
+	err error
+}
+
+func (c *failingListClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestReconcileRestartsRetried(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "myapp", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "example"}}}},
+		}}},
+	}
+
+	c := &failingListClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).Build()}
+	r := &AWSSecretController{Client: c, APIReader: c, Scheme: scheme}
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Spec:       mumoshuv1alpha1.AWSSecretSpec{RestartWorkloads: true},
+	}
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"},
+		Data:       map[string][]byte{"value": []byte("v2")},
+	}
+	hash := secretDataHash(desired)
+
+	// The content the workloads run with is unknown until recorded, so they are restarted only on version changes
+	got, err := r.reconcileRestarts(ctx, logr.Discard(), cr, desired, false)
+	if err != nil || got != hash {
+		t.Fatalf("want %s recorded without restarts, got %q, %v", hash, got, err)
+	}
+
+	cr.Status.RestartedHash = "old"
+	c.err = fmt.Errorf("unavailable")
+
+	got, err = r.reconcileRestarts(ctx, logr.Discard(), cr, desired, true)
+	if err == nil || got != "old" {
+		t.Fatalf("want the last restarted hash kept on errors, got %q, %v", got, err)
+	}
+
+	// Retried on the next reconciliation, although the versions no longer change
+	c.err = nil
+
+	got, err = r.reconcileRestarts(ctx, logr.Discard(), cr, desired, false)
+	if err != nil || got != hash {
+		t.Fatalf("want %s recorded after the restarts, got %q, %v", hash, got, err)
+	}
+
+	var d appsv1.Deployment
+	if err := c.Get(ctx, client.ObjectKeyFromObject(deployment), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Spec.Template.Annotations[checksumAnnotationKey(cr.Name)] != hash {
+		t.Errorf("want the deployment restarted for %s, got annotations %v", hash, d.Spec.Template.Annotations)
+	}
+}
+
+func TestRestartWorkloadsListsFromAPIServer(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "myapp", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "example"}}}},
+		}}},
+	}
+
+	apiserver := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).Build()
+
+	// The cached client fails to list, as the cache doesn't hold the workloads
+	r := &AWSSecretController{
+		Client:    &failingListClient{Client: apiserver, err: fmt.Errorf("no informer for workloads")},
+		APIReader: apiserver,
+		Scheme:    scheme,
+	}
+
+	cr := &mumoshuv1alpha1.AWSSecret{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"}}
+
+	if err := r.restartWorkloads(ctx, logr.Discard(), cr, "example", "v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	var d appsv1.Deployment
+	if err := apiserver.Get(ctx, client.ObjectKeyFromObject(deployment), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Spec.Template.Annotations[checksumAnnotationKey(cr.Name)] != "v2" {
+		t.Errorf("want the deployment restarted, got annotations %v", d.Spec.Template.Annotations)
+	}
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// rotationPollInterval is how often the controller checks the progress of a pending rotation
+	rotationPollInterval = 10 * time.Second
+
+	// rotationTimeout is how long a rotation may stay pending before it is reported as failed.
+	// It is the maximum execution time of a Lambda function.
+	rotationTimeout = 15 * time.Minute
+
+	stageCurrent = "AWSCURRENT"
+)
+
+// reconcileRotation rotates the Secrets Manager secret once per value of the rotate-requested-at annotation,
+// and tracks the progress of the rotation in status.
+// It returns true while the rotation is pending, so that the caller checks back sooner than the periodic resync.
+func (r *AWSSecretController) reconcileRotation(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) (bool, error) {
+	requestedAt := cr.Annotations[AnnotationRotateRequestedAt]
+	if requestedAt == "" {
+		return false, nil
+	}
+
+	if st := cr.Status.Rotation; st != nil && st.RequestedAt == requestedAt {
+		if st.Phase != mumoshuv1alpha1.RotationPending {
+			return false, nil
+		}
+		return r.trackRotation(ctx, reqLogger, cr)
+	}
+
+	secretId, err := sourceSecretId(cr)
+	if err != nil {
+		return false, r.rotationFailed(ctx, reqLogger, cr, requestedAt, err)
+	}
+
+	versionId, err := r.SyncContext.RotateSecret(secretId, rotationToken(cr, requestedAt))
+	if err != nil && isTransientError(err) {
+		// Retried with backoff. The rotation token makes the retries idempotent
+		return false, err
+	} else if err != nil {
+		return false, r.rotationFailed(ctx, reqLogger, cr, requestedAt, err)
+	}
+
+	reqLogger.Info("Started rotation of Secrets Manager secret", "secretId", secretId, "versionId", versionId)
+
+	now := metav1.Now()
+
+	return true, r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.Rotation = &mumoshuv1alpha1.RotationStatus{
+			RequestedAt: requestedAt,
+			VersionId:   versionId,
+			Phase:       mumoshuv1alpha1.RotationPending,
+			StartedAt:   &now,
+		}
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionRotated, metav1.ConditionFalse, mumoshuv1alpha1.ReasonRotationPending,
+			fmt.Sprintf("Waiting for version %s of %s to become %s", versionId, secretId, stageCurrent))
+	})
+}
+
+// rotationFailed records the terminal failure to start the rotation along with the annotation value,
+// like missing permissions or rotation Lambda functions, so that the rotation is retried only when the value changes
+func (r *AWSSecretController) rotationFailed(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret, requestedAt string, err error) error {
+	reqLogger.Info("Failed to start rotation of Secrets Manager secret", "error", err.Error())
+
+	now := metav1.Now()
+
+	return r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.Rotation = &mumoshuv1alpha1.RotationStatus{
+			RequestedAt: requestedAt,
+			Phase:       mumoshuv1alpha1.RotationFailed,
+			Message:     err.Error(),
+			StartedAt:   &now,
+			CompletedAt: &now,
+		}
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionRotated, metav1.ConditionFalse, mumoshuv1alpha1.ReasonRotationFailed, err.Error())
+	})
+}
+
+// trackRotation checks whether the version created by the pending rotation has become AWSCURRENT
+func (r *AWSSecretController) trackRotation(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) (bool, error) {
+	secretId, err := sourceSecretId(cr)
+	if err != nil {
+		return false, err
+	}
+
+	desc, err := r.SyncContext.DescribeSecret(secretId)
+	if err != nil {
+		return false, err
+	}
+
+	var stages map[string][]*string
+	if desc != nil {
+		stages = desc.VersionIdsToStages
+	}
+
+	st := cr.Status.Rotation
+
+	phase, message := rotationProgress(stages, st.VersionId, st.StartedAt.Time, time.Now())
+	if phase == mumoshuv1alpha1.RotationPending {
+		return true, nil
+	}
+
+	reqLogger.Info("Rotation of Secrets Manager secret completed", "secretId", secretId, "versionId", st.VersionId, "phase", phase)
+
+	status, reason := metav1.ConditionTrue, mumoshuv1alpha1.ReasonRotationSucceeded
+	if phase == mumoshuv1alpha1.RotationFailed {
+		status, reason = metav1.ConditionFalse, mumoshuv1alpha1.ReasonRotationFailed
+	}
+
+	now := metav1.Now()
+
+	return false, r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.Rotation.Phase = phase
+		st.Rotation.Message = message
+		st.Rotation.CompletedAt = &now
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionRotated, status, reason, message)
+	})
+}
+
+// rotationProgress returns the phase of the rotation that created the version, given the staging labels of the secret versions.
+// The rotation Lambda function creates the version labelled AWSPENDING asynchronously, so a missing version is
+// considered pending until the rotation times out.
+func rotationProgress(stages map[string][]*string, versionId string, startedAt, now time.Time) (mumoshuv1alpha1.RotationPhase, string) {
+	for _, s := range stages[versionId] {
+		if aws.StringValue(s) == stageCurrent {
+			return mumoshuv1alpha1.RotationSucceeded, fmt.Sprintf("Version %s is %s", versionId, stageCurrent)
+		}
+	}
+
+	if now.Sub(startedAt) > rotationTimeout {
+		return mumoshuv1alpha1.RotationFailed, fmt.Sprintf("Version %s did not become %s within %s. Check the logs of the rotation Lambda function",
+			versionId, stageCurrent, rotationTimeout)
+	}
+
+	return mumoshuv1alpha1.RotationPending, ""
+}
+
+// rotationToken returns the idempotency token of the rotation requested by the annotation value,
+// so that retrying a request whose outcome couldn't be recorded doesn't rotate the secret twice.
+func rotationToken(cr *mumoshuv1alpha1.AWSSecret, requestedAt string) string {
+	sum := sha256.Sum256([]byte(string(cr.UID) + "/" + requestedAt))
+	return hex.EncodeToString(sum[:])
+}
+
+// RotateSecret starts the rotation of the SecretsManager secret with its configured rotation Lambda function,
+// and returns the VersionId of the new version
+func (c *SyncContext) RotateSecret(secretId string, token string) (string, error) {
+	output, err := c.client().RotateSecret(&secretsmanager.RotateSecretInput{
+		SecretId:           &secretId,
+		ClientRequestToken: aws.String(token),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.VersionId), nil
+}
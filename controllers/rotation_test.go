@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRotationProgress(t *testing.T) {
+	startedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type testcase struct {
+		name   string
+		stages map[string][]*string
+		now    time.Time
+		want   mumoshuv1alpha1.RotationPhase
+	}
+
+	testcases := []testcase{
+		{
+			name:   "version not created yet",
+			stages: map[string][]*string{"v1": aws.StringSlice([]string{"AWSCURRENT"})},
+			now:    startedAt.Add(time.Minute),
+			want:   mumoshuv1alpha1.RotationPending,
+		},
+		{
+			name:   "version pending",
+			stages: map[string][]*string{"v1": aws.StringSlice([]string{"AWSCURRENT"}), "v2": aws.StringSlice([]string{"AWSPENDING"})},
+			now:    startedAt.Add(time.Minute),
+			want:   mumoshuv1alpha1.RotationPending,
+		},
+		{
+			name:   "version promoted",
+			stages: map[string][]*string{"v1": aws.StringSlice([]string{"AWSPREVIOUS"}), "v2": aws.StringSlice([]string{"AWSCURRENT"})},
+			now:    startedAt.Add(time.Minute),
+			want:   mumoshuv1alpha1.RotationSucceeded,
+		},
+		{
+			name:   "timed out",
+			stages: map[string][]*string{"v1": aws.StringSlice([]string{"AWSCURRENT"}), "v2": aws.StringSlice([]string{"AWSPENDING"})},
+			now:    startedAt.Add(time.Hour),
+			want:   mumoshuv1alpha1.RotationFailed,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, _ := rotationProgress(tc.stages, "v2", startedAt, tc.now)
+			if got != tc.want {
+				t.Errorf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+// rotatingSecretsManager fails to rotate secrets with err, or starts rotations creating version v2
+type rotatingSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	err   error
+	calls int
+}
+
+func (f *rotatingSecretsManager) RotateSecret(_ *secretsmanager.RotateSecretInput) (*secretsmanager.RotateSecretOutput, error) {
+	f.calls++
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &secretsmanager.RotateSecretOutput{VersionId: aws.String("v2")}, nil
+}
+
+func TestReconcileRotationRetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", Annotations: map[string]string{AnnotationRotateRequestedAt: "2022-01-01T00:00:00Z"}},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret"},
+			},
+		},
+	}
+
+	sm := &rotatingSecretsManager{err: awserr.NewRequestFailure(awserr.New("ThrottlingException", "rate exceeded", nil), 400, "")}
+
+	r := &AWSSecretController{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build(),
+		Scheme:      scheme,
+		SyncContext: &SyncContext{sm: sm},
+	}
+
+	get := func() *mumoshuv1alpha1.AWSSecret {
+		t.Helper()
+
+		var got mumoshuv1alpha1.AWSSecret
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(cr), &got); err != nil {
+			t.Fatal(err)
+		}
+		return &got
+	}
+
+	if _, err := r.reconcileRotation(ctx, logr.Discard(), get()); err == nil {
+		t.Fatal("want the transient error returned to be retried with backoff")
+	}
+
+	if st := get().Status.Rotation; st != nil {
+		t.Fatalf("want no failure recorded for the transient error, got %+v", st)
+	}
+
+	sm.err = nil
+
+	pending, err := r.reconcileRotation(ctx, logr.Discard(), get())
+	if err != nil || !pending {
+		t.Fatalf("want the rotation started on retry, got %v, %v", pending, err)
+	}
+
+	if st := get().Status.Rotation; st == nil || st.Phase != mumoshuv1alpha1.RotationPending || st.VersionId != "v2" {
+		t.Errorf("want the pending rotation of v2, got %+v", st)
+	}
+}
+
+func TestReconcileRotationRecordsTerminalErrors(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", Annotations: map[string]string{AnnotationRotateRequestedAt: "2022-01-01T00:00:00Z"}},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret"},
+			},
+		},
+	}
+
+	sm := &rotatingSecretsManager{err: awserr.NewRequestFailure(awserr.New("AccessDeniedException", "denied", nil), 400, "")}
+
+	r := &AWSSecretController{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build(),
+		Scheme:      scheme,
+		SyncContext: &SyncContext{sm: sm},
+	}
+
+	if _, err := r.reconcileRotation(ctx, logr.Discard(), cr.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got mumoshuv1alpha1.AWSSecret
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(cr), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := got.Status.Rotation; st == nil || st.Phase != mumoshuv1alpha1.RotationFailed {
+		t.Errorf("want the terminal error recorded as failed, got %+v", st)
+	}
+}
@@ -2,32 +2,89 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
 	"github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	"golang.org/x/time/rate"
 )
 
+// SyncContext holds the Secrets Manager client shared by all the reconciliations.
+// The client is constructed eagerly and never mutated afterwards, so that the SyncContext is safe for concurrent use.
 type SyncContext struct {
-	s  *session.Session
-	sm *secretsmanager.SecretsManager
+	sm secretsmanageriface.SecretsManagerAPI
+
+	// budget is charged for every API call made through the SyncContext, when non-nil
+	budget *callBudget
+
+	// regions are the clients of the fallback regions the secret values are read from when the primary region is unavailable.
+	// Only the primary region is used when nil.
+	regions *regionalClients
 }
 
-func newContext(s *session.Session) *SyncContext {
+// NewSyncContext returns a SyncContext with the client for the session.
+// The session is created from the environment when nil.
+// Every API call, including retries, waits for the limiter shared by all the reconciliations, unless the limiter is nil.
+func NewSyncContext(s *session.Session, limiter *rate.Limiter) *SyncContext {
+	if s == nil {
+		s = session.Must(session.NewSession())
+	}
+
+	newClient := func(cfgs ...*aws.Config) *secretsmanager.SecretsManager {
+		sm := secretsmanager.New(s, cfgs...)
+		if limiter != nil {
+			sm.Handlers.Send.PushFront(rateLimitHandler(limiter))
+		}
+		return sm
+	}
+
 	return &SyncContext{
-		s: s,
+		sm: newClient(),
+		regions: newRegionalClients(aws.StringValue(s.Config.Region), func(region string) secretsmanageriface.SecretsManagerAPI {
+			return newClient(aws.NewConfig().WithRegion(region))
+		}),
 	}
 }
 
-func (c *SyncContext) String(secretId string, versionId string) (*string, *string, error) {
-	if c.s == nil {
-		c.s = session.Must(session.NewSession())
+// rateLimitHandler returns the request handler that waits for the limiter before sending the request
+func rateLimitHandler(limiter *rate.Limiter) func(*request.Request) {
+	return func(req *request.Request) {
+		start := time.Now()
+
+		if err := limiter.Wait(req.Context()); err != nil {
+			req.Error = err
+			return
+		}
+
+		awsRateLimitWait.Observe(time.Since(start).Seconds())
 	}
+}
 
-	if c.sm == nil {
-		c.sm = secretsmanager.New(c.s)
+func (c *SyncContext) client() secretsmanageriface.SecretsManagerAPI {
+	c.charge()
+
+	return c.sm
+}
+
+// charge charges an API call to the budget, if any
+func (c *SyncContext) charge() {
+	if c.budget != nil {
+		c.budget.charge(time.Now())
 	}
+}
+
+// chargingTo returns a SyncContext sharing the clients, which charges the API calls to the budget
+func (c *SyncContext) chargingTo(budget *callBudget) *SyncContext {
+	return &SyncContext{sm: c.sm, budget: budget, regions: c.regions}
+}
 
+func (c *SyncContext) String(secretId string, versionId string) (*string, *string, error) {
 	var getSecInput *secretsmanager.GetSecretValueInput
 
 	if versionId == "" {
@@ -41,7 +98,7 @@ func (c *SyncContext) String(secretId string, versionId string) (*string, *strin
 		}
 	}
 
-	output, err := c.sm.GetSecretValue(getSecInput)
+	output, err := c.client().GetSecretValue(getSecInput)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -49,9 +106,70 @@ func (c *SyncContext) String(secretId string, versionId string) (*string, *strin
 	return output.SecretString, output.VersionId, nil
 }
 
-func (c *SyncContext) SecretsManagerSecretToKubernetesStringData(ref v1alpha1.SecretsManagerSecretRef) (map[string]string, error) {
-	sec, ver, err := c.String(ref.SecretId, ref.VersionId)
+// StringWithFailover is String that reads the replica in the fallback regions in order when the primary region is unavailable.
+// It also returns the region that served the value.
+func (c *SyncContext) StringWithFailover(secretId string, versionId string, fallbackRegions []string) (*string, *string, string, error) {
+	var sec, ver *string
+
+	region, err := c.failover(secretId, fallbackRegions, func(sm secretsmanageriface.SecretsManagerAPI, secretId string) error {
+		input := &secretsmanager.GetSecretValueInput{SecretId: &secretId}
+		if versionId != "" {
+			input.VersionId = &versionId
+		}
+
+		output, err := sm.GetSecretValue(input)
+		if err != nil {
+			return err
+		}
+
+		sec, ver = output.SecretString, output.VersionId
+
+		return nil
+	})
+
+	return sec, ver, region, err
+}
+
+// SecretsManagerSecretToKubernetesStringData returns the value of the version as string data,
+// and the region that served the value
+func (c *SyncContext) SecretsManagerSecretToKubernetesStringData(ref v1alpha1.SecretsManagerSecretRef, fallbackRegions []string) (map[string]string, string, error) {
+	sec, ver, region, err := c.StringWithFailover(ref.SecretId, ref.VersionId, fallbackRegions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m, err := awsSecretValueToMap(*sec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m["AWSVersionId"] = *ver
+
+	return m, region, nil
+}
+
+// SecretsManagerSecretToKubernetesData returns the value of the version as data, and the region that served the value
+func (c *SyncContext) SecretsManagerSecretToKubernetesData(ref v1alpha1.SecretsManagerSecretRef, fallbackRegions []string) (map[string][]byte, string, error) {
+	sec, ver, region, err := c.StringWithFailover(ref.SecretId, ref.VersionId, fallbackRegions)
 	if err != nil {
+		return nil, "", err
+	}
+
+	m, err := awsSecretValueToMapBytes(*sec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m["AWSVersionId"] = []byte(*ver)
+
+	return m, region, nil
+}
+
+// SecretsManagerSecretStageToKubernetesStringData is SecretsManagerSecretToKubernetesStringData for the version
+// labelled with the stage. It returns nil when no version has the label.
+func (c *SyncContext) SecretsManagerSecretStageToKubernetesStringData(secretId string, stage string, fallbackRegions []string) (map[string]string, error) {
+	sec, ver, err := c.StringAtStage(secretId, stage, fallbackRegions)
+	if err != nil || sec == nil {
 		return nil, err
 	}
 
@@ -65,9 +183,11 @@ func (c *SyncContext) SecretsManagerSecretToKubernetesStringData(ref v1alpha1.Se
 	return m, nil
 }
 
-func (c *SyncContext) SecretsManagerSecretToKubernetesData(ref v1alpha1.SecretsManagerSecretRef) (map[string][]byte, error) {
-	sec, ver, err := c.String(ref.SecretId, ref.VersionId)
-	if err != nil {
+// SecretsManagerSecretStageToKubernetesData is SecretsManagerSecretToKubernetesData for the version
+// labelled with the stage. It returns nil when no version has the label.
+func (c *SyncContext) SecretsManagerSecretStageToKubernetesData(secretId string, stage string, fallbackRegions []string) (map[string][]byte, error) {
+	sec, ver, err := c.StringAtStage(secretId, stage, fallbackRegions)
+	if err != nil || sec == nil {
 		return nil, err
 	}
 
@@ -81,6 +201,97 @@ func (c *SyncContext) SecretsManagerSecretToKubernetesData(ref v1alpha1.SecretsM
 	return m, nil
 }
 
+// StringAtStage returns the value and the VersionId of the version labelled with the stage, or nils when no version has the label.
+// It reads the replica in the fallback regions in order when the primary region is unavailable.
+func (c *SyncContext) StringAtStage(secretId string, stage string, fallbackRegions []string) (*string, *string, error) {
+	var sec, ver *string
+
+	_, err := c.failover(secretId, fallbackRegions, func(sm secretsmanageriface.SecretsManagerAPI, secretId string) error {
+		output, err := sm.GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId:     &secretId,
+			VersionStage: &stage,
+		})
+		if err != nil {
+			return err
+		}
+
+		sec, ver = output.SecretString, output.VersionId
+
+		return nil
+	})
+	if err != nil {
+		if isResourceNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	return sec, ver, nil
+}
+
+// DescribeSecret returns the metadata of the SecretsManager secret, or nil when it does not exist
+func (c *SyncContext) DescribeSecret(secretId string) (*secretsmanager.DescribeSecretOutput, error) {
+	output, err := c.client().DescribeSecret(&secretsmanager.DescribeSecretInput{
+		SecretId: &secretId,
+	})
+	if err != nil {
+		if isResourceNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// DescribeSecretWithFailover is DescribeSecret that describes the replica in the fallback regions in order
+// when the primary region is unavailable
+func (c *SyncContext) DescribeSecretWithFailover(secretId string, fallbackRegions []string) (*secretsmanager.DescribeSecretOutput, error) {
+	var output *secretsmanager.DescribeSecretOutput
+
+	_, err := c.failover(secretId, fallbackRegions, func(sm secretsmanageriface.SecretsManagerAPI, secretId string) error {
+		var err error
+		output, err = sm.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: &secretId})
+		return err
+	})
+	if err != nil {
+		if isResourceNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// CreateSecret creates the SecretsManager secret with the initial value, and returns the ARN and the VersionId
+func (c *SyncContext) CreateSecret(input *secretsmanager.CreateSecretInput) (*string, *string, error) {
+	output, err := c.client().CreateSecret(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return output.ARN, output.VersionId, nil
+}
+
+// PutSecretString stores a new version of the SecretsManager secret, and returns the ARN and the VersionId
+func (c *SyncContext) PutSecretString(secretId string, value string) (*string, *string, error) {
+	output, err := c.client().PutSecretValue(&secretsmanager.PutSecretValueInput{
+		SecretId:     &secretId,
+		SecretString: &value,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return output.ARN, output.VersionId, nil
+}
+
+func isResourceNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException
+}
+
 func awsSecretValueToMap(sec string) (map[string]string, error) {
 	m := map[string]string{}
 	jsonerr := json.Unmarshal([]byte(sec), &m)
@@ -1,9 +1,13 @@
 package controllers
 
 import (
+	"context"
+	"net/http"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/time/rate"
 )
 
 func TestAWSSecretValueToMap(t *testing.T) {
@@ -40,3 +44,28 @@ func TestAWSSecretValueToMap(t *testing.T) {
 		})
 	}
 }
+
+func TestRateLimitHandler(t *testing.T) {
+	limiter := rate.NewLimiter(1, 1)
+	handler := rateLimitHandler(limiter)
+
+	req := &request.Request{HTTPRequest: &http.Request{}}
+	req.SetContext(context.Background())
+
+	handler(req)
+	if req.Error != nil {
+		t.Fatalf("unexpected error within the burst: %v", req.Error)
+	}
+
+	// The request gives up waiting for the limiter when its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req = &request.Request{HTTPRequest: &http.Request{}}
+	req.SetContext(ctx)
+
+	handler(req)
+	if req.Error == nil {
+		t.Fatal("want an error for the request exceeding the rate limit after its context is done")
+	}
+}
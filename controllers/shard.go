@@ -0,0 +1,360 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	defaultShardLeaseDuration = 15 * time.Second
+	defaultShardRenewInterval = 5 * time.Second
+)
+
+var _ manager.Runnable = &Shard{}
+var _ manager.LeaderElectionRunnable = &Shard{}
+
+// Shard coordinates the replicas of the operator running in the sharded mode, where every replica is active
+// and reconciles only the AWSSecrets and PushSecrets it owns.
+//
+// Each replica renews its own Lease labelled with the shard group, and considers the replicas whose Leases
+// haven't expired as the members of the group. AWSSecrets and PushSecrets are assigned to the members by rendezvous hashing of
+// their namespace and name, so that only the objects of the joining or leaving replica move on rebalancing.
+//
+// A joining replica takes over its objects only one lease duration after joining. By then the other replicas,
+// which sync every renew interval, have dropped the objects, so that no object is reconciled by two replicas at once.
+type Shard struct {
+	// Client creates and renews the Lease, and lists the AWSSecrets and PushSecrets to enqueue on rebalancing
+	Client client.Client
+
+	// APIReader lists the Leases of the group, bypassing the cache that may not cover the namespace of the Leases
+	APIReader client.Reader
+
+	// Namespace is the namespace of the Leases
+	Namespace string
+
+	// Group is the name of the shard group. The Lease of the replica is named `<group>-<identity>`.
+	Group string
+
+	// Identity is the unique name of the replica, typically the pod name
+	Identity string
+
+	// LeaseDuration is how long the Lease of a replica that stopped renewing it is considered alive. Defaults to 15 seconds.
+	LeaseDuration time.Duration
+
+	// RenewInterval is the interval at which the Lease is renewed and the members are listed. Defaults to 5 seconds.
+	RenewInterval time.Duration
+
+	// Events enqueues the AWSSecrets the replica starts owning on rebalancing
+	Events chan<- event.GenericEvent
+
+	// PushSecretEvents enqueues the PushSecrets the replica starts owning on rebalancing. PushSecrets are not enqueued when nil.
+	PushSecretEvents chan<- event.GenericEvent
+
+	Log logr.Logger
+
+	mu      sync.RWMutex
+	members []string
+	// renewed is the time of the last successful sync, after which the Lease of this replica is valid for the lease duration
+	renewed time.Time
+	// joined is the time of the first successful sync after starting or after the Lease expired
+	joined time.Time
+	// claimed is true once the objects assigned to this replica have been enqueued after joining
+	claimed bool
+}
+
+// NeedLeaderElection returns false, because all the replicas are active in the sharded mode
+func (s *Shard) NeedLeaderElection() bool {
+	return false
+}
+
+// Owns returns true when the AWSSecret or the PushSecret is assigned to this replica.
+// Nothing is owned until one lease duration after the replica has joined the group, or once its Lease has expired without being renewed.
+func (s *Shard) Owns(key types.NamespacedName) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	if s.expired(now) || s.joining(now) {
+		return false
+	}
+
+	return shardOwner(s.members, key.String()) == s.Identity
+}
+
+// expired returns true when the Lease of this replica may have expired since the last sync. Must be called with the lock held.
+func (s *Shard) expired(now time.Time) bool {
+	return now.Sub(s.renewed) >= s.leaseDuration()
+}
+
+// joining returns true while the other replicas may still own the objects assigned to this replica,
+// because they haven't synced since this replica joined. Must be called with the lock held.
+func (s *Shard) joining(now time.Time) bool {
+	return now.Sub(s.joined) < s.leaseDuration()
+}
+
+// Start renews the Lease and rebalances the AWSSecrets on membership changes until the context is done,
+// and then deletes the Lease so that the other replicas take over immediately
+func (s *Shard) Start(ctx context.Context) error {
+	s.Log.Info("Joining shard group", "group", s.Group, "identity", s.Identity)
+
+	ticker := time.NewTicker(s.renewInterval())
+	defer ticker.Stop()
+
+	for {
+		if err := s.sync(ctx); err != nil && ctx.Err() == nil {
+			s.Log.Error(err, "Failed to sync shard membership", "group", s.Group)
+		}
+
+		select {
+		case <-ctx.Done():
+			return s.leave()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync renews the Lease of this replica, lists the members, and enqueues the AWSSecrets this replica starts owning.
+// When the Lease can't be renewed or the members can't be listed for longer than the lease duration,
+// this replica drops all its AWSSecrets, because the other replicas have taken them over once its Lease expired.
+func (s *Shard) sync(ctx context.Context) error {
+	now := time.Now()
+
+	if err := s.renew(ctx); err != nil {
+		s.expire(now)
+		return err
+	}
+
+	var leases coordinationv1.LeaseList
+	if err := s.APIReader.List(ctx, &leases, client.InNamespace(s.Namespace), client.MatchingLabels{LabelShardGroup: s.Group}); err != nil {
+		s.expire(now)
+		return err
+	}
+
+	members := liveMembers(leases.Items, s.leaseDuration(), now)
+
+	s.mu.Lock()
+	old := s.members
+	if s.expired(now) {
+		// The other replicas have taken over the objects while the Lease was expired, so this replica joins again
+		s.joined = now
+		s.claimed = false
+	}
+	joining := s.joining(now)
+	claim := !joining && !s.claimed
+	if claim {
+		// Nothing was owned while joining, so all the AWSSecrets assigned to this replica are enqueued
+		old = nil
+		s.claimed = true
+	}
+	s.members = members
+	s.renewed = now
+	s.mu.Unlock()
+
+	shardMembers.Set(float64(len(members)))
+
+	if joining {
+		s.Log.V(1).Info("Waiting for the other shard members to release the objects before taking them over", "group", s.Group, "members", members)
+		return nil
+	}
+
+	if !claim && reflect.DeepEqual(old, members) {
+		return nil
+	}
+
+	s.Log.Info("Shard members changed. Rebalancing", "group", s.Group, "old", old, "new", members)
+
+	return s.enqueueAcquired(ctx, old, members)
+}
+
+// expire drops the members, so that this replica owns nothing, when it hasn't synced since its Lease expired
+func (s *Shard) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.members == nil || !s.expired(now) {
+		return
+	}
+
+	s.Log.Info("Failed to renew the lease within the lease duration. Dropping all the owned objects", "group", s.Group, "members", s.members)
+
+	s.members = nil
+
+	shardMembers.Set(0)
+}
+
+// enqueueAcquired enqueues the AWSSecrets and PushSecrets assigned to this replica with the new members but not with the old ones.
+// The ones this replica no longer owns are skipped by the controllers.
+func (s *Shard) enqueueAcquired(ctx context.Context, old, members []string) error {
+	var awsSecrets mumoshuv1alpha1.AWSSecretList
+	if err := s.Client.List(ctx, &awsSecrets); err != nil {
+		return err
+	}
+
+	objs := make([]client.Object, 0, len(awsSecrets.Items))
+	for i := range awsSecrets.Items {
+		objs = append(objs, &awsSecrets.Items[i])
+	}
+
+	if err := s.sendAcquired(ctx, s.Events, objs, old, members); err != nil {
+		return err
+	}
+
+	if s.PushSecretEvents == nil {
+		return nil
+	}
+
+	var pushSecrets mumoshuv1alpha1.PushSecretList
+	if err := s.Client.List(ctx, &pushSecrets); err != nil {
+		return err
+	}
+
+	objs = make([]client.Object, 0, len(pushSecrets.Items))
+	for i := range pushSecrets.Items {
+		objs = append(objs, &pushSecrets.Items[i])
+	}
+
+	return s.sendAcquired(ctx, s.PushSecretEvents, objs, old, members)
+}
+
+// sendAcquired sends the objects assigned to this replica with the new members but not with the old ones to the channel
+func (s *Shard) sendAcquired(ctx context.Context, events chan<- event.GenericEvent, objs []client.Object, old, members []string) error {
+	for _, obj := range objs {
+		key := client.ObjectKeyFromObject(obj).String()
+
+		if shardOwner(members, key) != s.Identity || shardOwner(old, key) == s.Identity {
+			continue
+		}
+
+		select {
+		case events <- event.GenericEvent{Object: obj}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// renew creates or renews the Lease of this replica
+func (s *Shard) renew(ctx context.Context) error {
+	now := metav1.NowMicro()
+	seconds := int32(s.leaseDuration().Seconds())
+
+	lease := &coordinationv1.Lease{}
+	err := s.APIReader.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.leaseName()}, lease)
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.leaseName(),
+				Namespace: s.Namespace,
+				Labels:    map[string]string{LabelShardGroup: s.Group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return s.Client.Create(ctx, lease)
+	} else if err != nil {
+		return err
+	}
+
+	lease.Spec.HolderIdentity = &s.Identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+
+	return s.Client.Update(ctx, lease)
+}
+
+// leave deletes the Lease of this replica, so that the other replicas take over its AWSSecrets without waiting for the Lease to expire
+func (s *Shard) leave() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.renewInterval())
+	defer cancel()
+
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: s.Namespace, Name: s.leaseName()}}
+	if err := s.Client.Delete(ctx, lease); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	s.Log.Info("Left shard group", "group", s.Group, "identity", s.Identity)
+
+	return nil
+}
+
+func (s *Shard) leaseName() string {
+	return s.Group + "-" + s.Identity
+}
+
+func (s *Shard) leaseDuration() time.Duration {
+	if s.LeaseDuration > 0 {
+		return s.LeaseDuration
+	}
+	return defaultShardLeaseDuration
+}
+
+func (s *Shard) renewInterval() time.Duration {
+	if s.RenewInterval > 0 {
+		return s.RenewInterval
+	}
+	return defaultShardRenewInterval
+}
+
+// liveMembers returns the sorted identities of the holders of the Leases renewed within the lease duration
+func liveMembers(leases []coordinationv1.Lease, leaseDuration time.Duration, now time.Time) []string {
+	var members []string
+
+	for _, l := range leases {
+		if l.Spec.HolderIdentity == nil || l.Spec.RenewTime == nil {
+			continue
+		}
+
+		d := leaseDuration
+		if l.Spec.LeaseDurationSeconds != nil {
+			d = time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second
+		}
+
+		if l.Spec.RenewTime.Add(d).Before(now) {
+			continue
+		}
+
+		members = append(members, *l.Spec.HolderIdentity)
+	}
+
+	sort.Strings(members)
+
+	return members
+}
+
+// shardOwner returns the member the key is assigned to by rendezvous hashing, or an empty string when there are no members
+func shardOwner(members []string, key string) string {
+	var (
+		owner string
+		max   uint64
+	)
+
+	for _, m := range members {
+		sum := sha256.Sum256([]byte(m + "\x00" + key))
+		if w := binary.BigEndian.Uint64(sum[:8]); owner == "" || w > max {
+			owner, max = m, w
+		}
+	}
+
+	return owner
+}
@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestShardOwner(t *testing.T) {
+	const n = 3000
+
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("ns-%d/awssecret-%d", i%50, i)
+	}
+
+	members := []string{"a", "b", "c"}
+
+	owned := map[string]int{}
+	for _, k := range keys {
+		owned[shardOwner(members, k)]++
+	}
+
+	for _, m := range members {
+		// Each member should own roughly a third of the keys
+		if owned[m] < n/3*8/10 || owned[m] > n/3*12/10 {
+			t.Errorf("unbalanced shards: %v", owned)
+		}
+	}
+
+	joined := append([]string{"d"}, members...)
+
+	for _, k := range keys {
+		before, after := shardOwner(members, k), shardOwner(joined, k)
+		if before != after && after != "d" {
+			t.Fatalf("%s moved from %s to %s instead of the joining member", k, before, after)
+		}
+	}
+
+	if got := shardOwner(nil, keys[0]); got != "" {
+		t.Errorf("want no owner without members, got %q", got)
+	}
+}
+
+func TestLiveMembers(t *testing.T) {
+	now := time.Now()
+
+	lease := func(holder string, renewedAgo time.Duration) coordinationv1.Lease {
+		renewTime := metav1.NewMicroTime(now.Add(-renewedAgo))
+		return coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{HolderIdentity: &holder, RenewTime: &renewTime}}
+	}
+
+	leases := []coordinationv1.Lease{
+		lease("c", 5*time.Second),
+		lease("a", 0),
+		lease("expired", time.Minute),
+		{},
+	}
+
+	got := liveMembers(leases, 15*time.Second, now)
+	if diff := cmp.Diff([]string{"a", "c"}, got); diff != "" {
+		t.Errorf("unexpected members (-want +got):\n%s", diff)
+	}
+}
+
+func TestShardSync(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := 0; i < 10; i++ {
+		builder = builder.WithObjects(&mumoshuv1alpha1.AWSSecret{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("example-%d", i), Namespace: "default"}})
+	}
+	builder = builder.WithObjects(&mumoshuv1alpha1.PushSecret{ObjectMeta: metav1.ObjectMeta{Name: "pushed", Namespace: "default"}})
+	c := builder.Build()
+
+	events := make(chan event.GenericEvent, 10)
+	pushSecretEvents := make(chan event.GenericEvent, 1)
+
+	s := &Shard{
+		Client:    c,
+		APIReader: c,
+		Namespace: "operator",
+		Group:     "aws-secret-operator",
+		Identity:  "replica-0",
+		Events:    events,
+		Log:       logr.Discard(),
+
+		PushSecretEvents: pushSecretEvents,
+	}
+
+	if s.Owns(types.NamespacedName{Namespace: "default", Name: "example-0"}) {
+		t.Fatal("owns an awssecret before joining the group")
+	}
+
+	if err := s.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var lease coordinationv1.Lease
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "operator", Name: "aws-secret-operator-replica-0"}, &lease); err != nil {
+		t.Fatal(err)
+	}
+
+	// The joining member owns and enqueues nothing until one lease duration has passed
+	if got := len(events); got != 0 {
+		t.Errorf("want no enqueued awssecrets while joining, got %d", got)
+	}
+
+	if s.Owns(types.NamespacedName{Namespace: "default", Name: "example-0"}) {
+		t.Error("owns an awssecret while joining the group")
+	}
+
+	s.joined = time.Now().Add(-s.leaseDuration())
+
+	if err := s.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The only member owns and enqueues all the awssecrets and pushsecrets
+	if got := len(events); got != 10 {
+		t.Errorf("want 10 enqueued awssecrets, got %d", got)
+	}
+
+	if got := len(pushSecretEvents); got != 1 {
+		t.Errorf("want 1 enqueued pushsecret, got %d", got)
+	}
+
+	if !s.Owns(types.NamespacedName{Namespace: "default", Name: "example-0"}) {
+		t.Error("the only member doesn't own the awssecret")
+	}
+
+	// Renewing without membership changes enqueues nothing
+	if err := s.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(events); got != 10 {
+		t.Errorf("want no more enqueued awssecrets, got %d", got-10)
+	}
+}
+
+// unavailableReader fails all reads, like an apiserver unreachable from a partitioned replica
+type unavailableReader struct{}
+
+func (unavailableReader) Get(context.Context, client.ObjectKey, client.Object) error {
+	return errors.New("connection refused")
+}
+
+func (unavailableReader) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return errors.New("connection refused")
+}
+
+func TestShardExpiry(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&mumoshuv1alpha1.AWSSecret{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"}}).Build()
+
+	events := make(chan event.GenericEvent, 10)
+
+	s := &Shard{
+		Client:    c,
+		APIReader: c,
+		Namespace: "operator",
+		Group:     "aws-secret-operator",
+		Identity:  "replica-0",
+		Events:    events,
+		Log:       logr.Discard(),
+	}
+
+	key := types.NamespacedName{Namespace: "default", Name: "example"}
+
+	if err := s.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.joined = time.Now().Add(-s.leaseDuration())
+	if err := s.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-events
+
+	s.APIReader = unavailableReader{}
+
+	// Failing to renew within the lease duration keeps the awssecrets owned
+	if err := s.sync(ctx); err == nil {
+		t.Fatal("want the renewal failed")
+	}
+	if !s.Owns(key) {
+		t.Fatal("dropped the awssecrets before the lease expired")
+	}
+
+	// The other replicas take over once the lease expires
+	s.renewed = time.Now().Add(-s.leaseDuration())
+
+	if err := s.sync(ctx); err == nil {
+		t.Fatal("want the renewal failed")
+	}
+	if s.Owns(key) {
+		t.Fatal("still owns the awssecret after the lease expired")
+	}
+
+	// Rejoining enqueues the awssecrets skipped while the lease was expired
+	s.APIReader = c
+
+	if err := s.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.Owns(key) || len(events) != 0 {
+		t.Fatal("took the awssecret over before the other replicas released it")
+	}
+
+	s.joined = time.Now().Add(-s.leaseDuration())
+
+	if err := s.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Owns(key) || len(events) != 1 {
+		t.Errorf("want the awssecret owned and enqueued again after rejoining, got %d enqueued", len(events))
+	}
+}
+
+func TestShardHandover(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	var keys []types.NamespacedName
+	for i := 0; i < 10; i++ {
+		key := types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("example-%d", i)}
+		keys = append(keys, key)
+		builder = builder.WithObjects(&mumoshuv1alpha1.AWSSecret{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}})
+	}
+	c := builder.Build()
+
+	newShard := func(identity string, events chan<- event.GenericEvent) *Shard {
+		return &Shard{
+			Client:    c,
+			APIReader: c,
+			Namespace: "operator",
+			Group:     "aws-secret-operator",
+			Identity:  identity,
+			Events:    events,
+			Log:       logr.Discard(),
+		}
+	}
+
+	events0 := make(chan event.GenericEvent, 10)
+	s0 := newShard("replica-0", events0)
+
+	if err := s0.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s0.joined = time.Now().Add(-s0.leaseDuration())
+	if err := s0.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	events1 := make(chan event.GenericEvent, 10)
+	s1 := newShard("replica-1", events1)
+
+	// No awssecret is owned by both replicas at any point of the handover
+	assertOwnedOnce := func(stage string) {
+		t.Helper()
+
+		for _, key := range keys {
+			if s0.Owns(key) && s1.Owns(key) {
+				t.Errorf("%s: %s is owned by both replicas", stage, key)
+			}
+		}
+	}
+
+	assertOwnedOnce("before joining")
+
+	// The joining replica's Lease exists, but the existing replica hasn't synced yet
+	if err := s1.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertOwnedOnce("joined")
+
+	// The existing replica drops the awssecrets assigned to the joining replica on its next sync
+	if err := s0.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertOwnedOnce("released")
+
+	var moved int
+	for _, key := range keys {
+		if !s0.Owns(key) {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(keys) {
+		t.Fatalf("want some but not all of the awssecrets moved, got %d", moved)
+	}
+
+	if len(events1) != 0 {
+		t.Errorf("want no enqueued awssecrets while joining, got %d", len(events1))
+	}
+
+	// The joining replica takes over the released awssecrets once one lease duration has passed
+	s1.joined = time.Now().Add(-s1.leaseDuration())
+
+	if err := s1.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertOwnedOnce("taken over")
+
+	for _, key := range keys {
+		if s0.Owns(key) == s1.Owns(key) {
+			t.Errorf("want %s owned by exactly one replica", key)
+		}
+	}
+
+	if got := len(events1); got != moved {
+		t.Errorf("want %d enqueued awssecrets after taking over, got %d", moved, got)
+	}
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// sqsErrorBackoff is how long the poller waits before receiving messages again after SQS returned an error
+const sqsErrorBackoff = 5 * time.Second
+
+var _ manager.Runnable = &SQSPoller{}
+
+// SQSPoller long-polls an SQS queue fed by EventBridge rules for Secrets Manager events,
+// and enqueues the AWSSecrets referencing the changed secrets.
+// It is the alternative to EventReceiver for clusters that can't receive inbound HTTP requests.
+//
+// A message is deleted only after the affected AWSSecrets have been enqueued.
+// Messages that fail to be enqueued or parsed are left in the queue to be retried after the visibility timeout,
+// and eventually moved to the dead-letter queue by the redrive policy of the queue.
+type SQSPoller struct {
+	QueueURL string
+
+	SQS      sqsiface.SQSAPI
+	Enqueuer Enqueuer
+	Log      logr.Logger
+}
+
+// Start polls the queue until the context is done
+func (p *SQSPoller) Start(ctx context.Context) error {
+	p.Log.Info("Starting sqs poller", "queueURL", p.QueueURL)
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+			p.Log.Error(err, "Failed to receive messages from sqs", "queueURL", p.QueueURL)
+
+			select {
+			case <-time.After(sqsErrorBackoff):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// poll receives a batch of messages and deletes the ones whose AWSSecrets have been enqueued
+func (p *SQSPoller) poll(ctx context.Context) error {
+	output, err := p.SQS.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(p.QueueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(20),
+		AttributeNames:      aws.StringSlice([]string{sqs.MessageSystemAttributeNameSentTimestamp}),
+	})
+	if err != nil {
+		return err
+	}
+
+	var done []*sqs.DeleteMessageBatchRequestEntry
+
+	for i, m := range output.Messages {
+		sqsMessagesReceived.Inc()
+
+		if !p.handle(ctx, m) {
+			continue
+		}
+
+		done = append(done, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: m.ReceiptHandle,
+		})
+	}
+
+	if len(done) == 0 {
+		return nil
+	}
+
+	res, err := p.SQS.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(p.QueueURL),
+		Entries:  done,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range res.Failed {
+		// The message is redelivered after the visibility timeout, which only results in a redundant resync
+		p.Log.Info("Failed to delete sqs message", "id", aws.StringValue(f.Id), "code", aws.StringValue(f.Code), "message", aws.StringValue(f.Message))
+	}
+
+	return nil
+}
+
+// handle enqueues the AWSSecrets affected by the message, and returns true when the message can be deleted
+func (p *SQSPoller) handle(ctx context.Context, m *sqs.Message) bool {
+	ids, err := secretIdsOfEvent(unwrapSNSNotification([]byte(aws.StringValue(m.Body))))
+	if err != nil {
+		sqsPoisonMessages.Inc()
+		p.Log.Info("Ignoring malformed sqs message", "messageId", aws.StringValue(m.MessageId), "error", err.Error())
+		return false
+	}
+
+	n, err := p.Enqueuer.Enqueue(ctx, ids)
+	if err != nil {
+		p.Log.Error(err, "Failed to enqueue awssecrets", "messageId", aws.StringValue(m.MessageId), "secretIds", ids)
+		return false
+	}
+
+	if sent, err := strconv.ParseInt(aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64); err == nil {
+		sqsMessageLag.Observe(time.Since(time.UnixMilli(sent)).Seconds())
+	}
+
+	if n > 0 {
+		p.Log.Info("Enqueued awssecrets for changed secrets", "secretIds", ids, "count", n)
+	}
+
+	return true
+}
+
+// unwrapSNSNotification returns the message carried by the SNS notification when the event was delivered via an SNS topic
+// without raw message delivery, or the body as is otherwise
+func unwrapSNSNotification(body []byte) []byte {
+	var n struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+	}
+
+	if err := json.Unmarshal(body, &n); err != nil || n.Type != "Notification" {
+		return body
+	}
+
+	return []byte(n.Message)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+
+	messages []*sqs.Message
+	deleted  []string
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(_ aws.Context, _ *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	return &sqs.ReceiveMessageOutput{Messages: f.messages}, nil
+}
+
+func (f *fakeSQS) DeleteMessageBatchWithContext(_ aws.Context, input *sqs.DeleteMessageBatchInput, _ ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	for _, e := range input.Entries {
+		f.deleted = append(f.deleted, aws.StringValue(e.ReceiptHandle))
+	}
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+type fakeEnqueuer struct {
+	failing map[string]bool
+}
+
+func (f *fakeEnqueuer) Enqueue(_ context.Context, secretIds []string) (int, error) {
+	for _, id := range secretIds {
+		if f.failing[id] {
+			return 0, errors.New("enqueue failed")
+		}
+	}
+	return len(secretIds), nil
+}
+
+func TestSQSPollerDeletesOnlyEnqueuedMessages(t *testing.T) {
+	event := func(secretId string) *string {
+		return aws.String(`{"source":"aws.secretsmanager","detail":{"eventName":"PutSecretValue","requestParameters":{"secretId":"` + secretId + `"}}}`)
+	}
+
+	fake := &fakeSQS{
+		messages: []*sqs.Message{
+			{ReceiptHandle: aws.String("enqueued"), Body: event("prod/a")},
+			{ReceiptHandle: aws.String("failed"), Body: event("prod/failing")},
+			{ReceiptHandle: aws.String("poison"), Body: aws.String("not json")},
+			{ReceiptHandle: aws.String("via-sns"), Body: aws.String(`{"Type":"Notification","Message":` + `"{\"source\":\"aws.secretsmanager\",\"detail\":{\"eventName\":\"RotationSucceeded\"}}"}`)},
+			{ReceiptHandle: aws.String("irrelevant"), Body: aws.String(`{"source":"aws.ec2","detail":{}}`)},
+		},
+	}
+
+	p := &SQSPoller{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/secrets",
+		SQS:      fake,
+		Enqueuer: &fakeEnqueuer{failing: map[string]bool{"prod/failing": true}},
+		Log:      logr.Discard(),
+	}
+
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"enqueued", "via-sns", "irrelevant"}
+	if diff := cmp.Diff(want, fake.deleted); diff != "" {
+		t.Errorf("unexpected deleted messages (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	errs "github.com/pkg/errors"
+)
+
+// versionIdKeyPrefix is the prefix of the keys holding the VersionIds of the synced versions
+const versionIdKeyPrefix = "AWSVersionId"
+
+var allStagingLabels = []mumoshuv1alpha1.StagingLabel{
+	mumoshuv1alpha1.StagingLabelPrevious,
+	mumoshuv1alpha1.StagingLabelPending,
+}
+
+// additionalStageLabels returns the staging labels of the additional versions emitted in the output
+func additionalStageLabels(cr *mumoshuv1alpha1.AWSSecret, output mumoshuv1alpha1.AdditionalStagesOutput) []mumoshuv1alpha1.StagingLabel {
+	s := cr.Spec.AdditionalStages
+	if s == nil {
+		return nil
+	}
+
+	o := s.Output
+	if o == "" {
+		o = mumoshuv1alpha1.AdditionalStagesOutputKeys
+	}
+
+	if o != output {
+		return nil
+	}
+
+	if len(s.Labels) == 0 {
+		return allStagingLabels
+	}
+
+	return s.Labels
+}
+
+// stageSuffix returns the suffix of the keys and the Secret name the version labelled with the stage is emitted under
+func stageSuffix(stage mumoshuv1alpha1.StagingLabel) string {
+	return strings.ToLower(strings.TrimPrefix(string(stage), "AWS"))
+}
+
+// stageSecretName returns the name of the separate Secret the version labelled with the stage is emitted into
+func stageSecretName(cr *mumoshuv1alpha1.AWSSecret, stage mumoshuv1alpha1.StagingLabel) string {
+	return cr.Name + "-" + stageSuffix(stage)
+}
+
+// stageData returns the data of the versions of the source secrets labelled with the stage.
+// Both maps are nil when none of the source secrets has a version with the label.
+// The values are read from the fallback regions when the primary region is unavailable, like the synced version.
+func (r *AWSSecretController) stageData(cr *mumoshuv1alpha1.AWSSecret, stage mumoshuv1alpha1.StagingLabel) (map[string]string, map[string][]byte, error) {
+	var (
+		stringData map[string]string
+		data       map[string][]byte
+		err        error
+	)
+
+	if ref := cr.Spec.StringDataFrom.SecretsManagerSecretRef; ref.SecretId != "" {
+		stringData, err = r.SyncContext.SecretsManagerSecretStageToKubernetesStringData(ref.SecretId, string(stage), r.fallbackRegions(cr))
+		if err != nil {
+			return nil, nil, errs.Wrapf(err, "failed to get %s json secret as map", stage)
+		}
+	}
+
+	if ref := cr.Spec.DataFrom.SecretsManagerSecretRef; ref.SecretId != "" {
+		data, err = r.SyncContext.SecretsManagerSecretStageToKubernetesData(ref.SecretId, string(stage), r.fallbackRegions(cr))
+		if err != nil {
+			return nil, nil, errs.Wrapf(err, "failed to get %s json secret as map", stage)
+		}
+	}
+
+	return stringData, data, nil
+}
+
+// addStageKeys adds the keys of the additional versions emitted as keys to the data of the desired Secret
+func (r *AWSSecretController) addStageKeys(cr *mumoshuv1alpha1.AWSSecret, stringData map[string]string, data map[string][]byte) error {
+	for _, stage := range additionalStageLabels(cr, mumoshuv1alpha1.AdditionalStagesOutputKeys) {
+		sd, d, err := r.stageData(cr, stage)
+		if err != nil {
+			return err
+		}
+
+		suffix := "_" + stageSuffix(stage)
+
+		for k, v := range sd {
+			stringData[k+suffix] = v
+		}
+
+		for k, v := range d {
+			data[k+suffix] = v
+		}
+	}
+
+	return nil
+}
+
+// reconcileStageSecrets writes the additional versions emitted as separate Secrets, and deletes the separate Secrets
+// that are no longer desired. The secret values are read only when the VersionIds of the additional versions
+// differ from the ones recorded in the status, or the Secrets need rewriting.
+func (r *AWSSecretController) reconcileStageSecrets(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) error {
+	if cr.Spec.AdditionalStages == nil && cr.Status.StageSecretVersions == "" {
+		// Neither emits additional versions as Secrets, nor has emitted any to delete
+		return nil
+	}
+
+	desiredStages := map[mumoshuv1alpha1.StagingLabel]bool{}
+	if cr.Spec.CreationPolicy != mumoshuv1alpha1.CreationPolicyNone {
+		for _, stage := range additionalStageLabels(cr, mumoshuv1alpha1.AdditionalStagesOutputSecrets) {
+			desiredStages[stage] = true
+		}
+	}
+
+	var versions map[string]string
+	if len(desiredStages) > 0 {
+		var err error
+		versions, err = r.resolveStageVersions(cr, desiredStages)
+		if err != nil {
+			return err
+		}
+	}
+
+	stageVersions := formatSourceVersions(versions)
+	unchanged := stageVersions == cr.Status.StageSecretVersions
+
+	for _, stage := range allStagingLabels {
+		name := stageSecretName(cr, stage)
+
+		// The Secrets of additional versions are written with the managed-by label and therefore cached.
+		// The apiserver is asked only before writing a Secret missing from the cache, in case a foreign Secret has the name.
+		key := types.NamespacedName{Namespace: cr.Namespace, Name: name}
+
+		current := &corev1.Secret{}
+		err := r.Client.Get(ctx, key, current)
+		if errors.IsNotFound(err) && desiredStages[stage] {
+			err = r.apiReader().Get(ctx, key, current)
+		}
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			current = nil
+		}
+
+		if current != nil && !metav1.IsControlledBy(current, cr) {
+			if !desiredStages[stage] {
+				continue
+			}
+			return r.updateStatus(ctx, cr, setReadyCondition(cr, metav1.ConditionFalse, mumoshuv1alpha1.ReasonForeignSecretExists,
+				fmt.Sprintf("Secret %s for the %s version already exists and is not owned by this AWSSecret", name, stage)))
+		}
+
+		labels := map[string]string{LabelManagedBy: managedByAWSSecret}
+		var annotations map[string]string
+		if m := cr.Spec.Metadata; m != nil {
+			for k, v := range m.Labels {
+				labels[k] = v
+			}
+			annotations = m.Annotations
+		}
+
+		var (
+			stringData map[string]string
+			data       map[string][]byte
+		)
+
+		if desiredStages[stage] && (!unchanged || current == nil && hasStageVersion(versions, stage)) {
+			stringData, data, err = r.stageData(cr, stage)
+			if err != nil {
+				return err
+			}
+		} else if desiredStages[stage] && current != nil {
+			// The versions written into the Secret are unchanged, so only its metadata may need updating
+			if len(changedMetadata(current, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations}})) == 0 {
+				continue
+			}
+
+			stringData, data, err = r.stageData(cr, stage)
+			if err != nil {
+				return err
+			}
+		}
+
+		if stringData == nil && data == nil {
+			if current != nil {
+				reqLogger.Info("Deleting the Secret of the additional version", "stage", stage, "name", name)
+				if err := r.Client.Delete(ctx, current, client.Preconditions{UID: &current.UID}); err != nil && !errors.IsNotFound(err) {
+					return err
+				}
+			}
+			continue
+		}
+
+		desired := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   cr.Namespace,
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Data:       data,
+			StringData: stringData,
+			Type:       cr.Spec.Type,
+		}
+
+		if err := controllerutil.SetControllerReference(cr, desired, r.Scheme); err != nil {
+			return err
+		}
+
+		if current != nil && !versionIdsChanged(current, desired) && len(changedMetadata(current, desired)) == 0 {
+			continue
+		}
+
+		reqLogger.Info("Writing the Secret of the additional version", "stage", stage, "name", name)
+
+		if err := r.applySecret(ctx, desired); err != nil {
+			return err
+		}
+	}
+
+	return r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.StageSecretVersions = stageVersions
+	})
+}
+
+// resolveStageVersions returns the VersionIds of the versions of the source secrets labelled with the stages,
+// keyed by the source name suffixed with the stage, by describing each source secret once.
+// The secrets are described in the fallback regions when the primary region is unavailable.
+func (r *AWSSecretController) resolveStageVersions(cr *mumoshuv1alpha1.AWSSecret, stages map[mumoshuv1alpha1.StagingLabel]bool) (map[string]string, error) {
+	versions := map[string]string{}
+	described := map[string]*secretsmanager.DescribeSecretOutput{}
+
+	for _, s := range []sourceRef{
+		{name: "stringDataFrom", ref: cr.Spec.StringDataFrom.SecretsManagerSecretRef},
+		{name: "dataFrom", ref: cr.Spec.DataFrom.SecretsManagerSecretRef},
+	} {
+		if s.ref.SecretId == "" {
+			continue
+		}
+
+		desc, ok := described[s.ref.SecretId]
+		if !ok {
+			var err error
+			desc, err = r.SyncContext.DescribeSecretWithFailover(s.ref.SecretId, r.fallbackRegions(cr))
+			if err != nil {
+				return nil, errs.Wrap(err, "failed to describe secrets manager secret")
+			}
+			if desc == nil {
+				return nil, fmt.Errorf("secrets manager secret %s does not exist", s.ref.SecretId)
+			}
+			described[s.ref.SecretId] = desc
+		}
+
+		for _, stage := range allStagingLabels {
+			if !stages[stage] {
+				continue
+			}
+			if v := versionWithStage(desc.VersionIdsToStages, string(stage)); v != "" {
+				versions[s.name+"_"+stageSuffix(stage)] = v
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+// hasStageVersion returns true when any of the source secrets has a version labelled with the stage
+func hasStageVersion(versions map[string]string, stage mumoshuv1alpha1.StagingLabel) bool {
+	for k := range versions {
+		if strings.HasSuffix(k, "_"+stageSuffix(stage)) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionIdsChanged returns true when any of the VersionIds of the synced versions recorded in the current Secret
+// differs from the desired Secret, including the ones of the additional versions that appeared or disappeared
+func versionIdsChanged(current, desired *corev1.Secret) bool {
+	want := secretForApply(desired).Data
+
+	for k, v := range want {
+		if strings.HasPrefix(k, versionIdKeyPrefix) && string(current.Data[k]) != string(v) {
+			return true
+		}
+	}
+
+	for _, k := range ownedDataKeys(current) {
+		if _, ok := want[k]; !ok && strings.HasPrefix(k, versionIdKeyPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestVersionIdsChanged(t *testing.T) {
+	owned := []metav1.ManagedFieldsEntry{
+		{
+			Manager:   FieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{},"f:AWSVersionId":{},"f:AWSVersionId_pending":{}}}`)},
+		},
+	}
+
+	type testcase struct {
+		name    string
+		current *corev1.Secret
+		desired *corev1.Secret
+		want    bool
+	}
+
+	testcases := []testcase{
+		{
+			name:    "unchanged",
+			current: &corev1.Secret{Data: map[string][]byte{"AWSVersionId": []byte("v1")}},
+			desired: &corev1.Secret{StringData: map[string]string{"AWSVersionId": "v1"}},
+		},
+		{
+			name:    "current version changed",
+			current: &corev1.Secret{Data: map[string][]byte{"AWSVersionId": []byte("v1")}},
+			desired: &corev1.Secret{StringData: map[string]string{"AWSVersionId": "v2"}},
+			want:    true,
+		},
+		{
+			name:    "unchanged dataFrom",
+			current: &corev1.Secret{Data: map[string][]byte{"AWSVersionId": []byte("v1")}},
+			desired: &corev1.Secret{Data: map[string][]byte{"AWSVersionId": []byte("v1")}},
+		},
+		{
+			name:    "pending version appeared",
+			current: &corev1.Secret{Data: map[string][]byte{"AWSVersionId": []byte("v1")}},
+			desired: &corev1.Secret{StringData: map[string]string{"AWSVersionId": "v1", "AWSVersionId_pending": "v2"}},
+			want:    true,
+		},
+		{
+			name: "pending version disappeared",
+			current: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{ManagedFields: owned},
+				Data:       map[string][]byte{"AWSVersionId": []byte("v1"), "AWSVersionId_pending": []byte("v2")},
+			},
+			desired: &corev1.Secret{StringData: map[string]string{"AWSVersionId": "v1"}},
+			want:    true,
+		},
+		{
+			name:    "version keys of other managers are ignored",
+			current: &corev1.Secret{Data: map[string][]byte{"AWSVersionId": []byte("v1"), "AWSVersionId_other": []byte("x")}},
+			desired: &corev1.Secret{StringData: map[string]string{"AWSVersionId": "v1"}},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := versionIdsChanged(tc.current, tc.desired); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+// stagedSecretsManager serves the version labelled AWSPREVIOUS, and counts the reads of secret values
+type stagedSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	previous string
+	reads    int
+}
+
+func (f *stagedSecretsManager) DescribeSecret(*secretsmanager.DescribeSecretInput) (*secretsmanager.DescribeSecretOutput, error) {
+	return &secretsmanager.DescribeSecretOutput{
+		VersionIdsToStages: map[string][]*string{
+			"v-current": aws.StringSlice([]string{stageCurrent}),
+			f.previous:  aws.StringSlice([]string{string(mumoshuv1alpha1.StagingLabelPrevious)}),
+		},
+	}, nil
+}
+
+func (f *stagedSecretsManager) GetSecretValue(*secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	f.reads++
+
+	return &secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String(`{"password":"old"}`),
+		VersionId:    aws.String(f.previous),
+	}, nil
+}
+
+func TestStageSecretsSkipReads(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", UID: "uid"},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret", VersionId: "v-current"},
+			},
+			AdditionalStages: &mumoshuv1alpha1.AdditionalStages{
+				Output: mumoshuv1alpha1.AdditionalStagesOutputSecrets,
+				Labels: []mumoshuv1alpha1.StagingLabel{mumoshuv1alpha1.StagingLabelPrevious},
+			},
+		},
+	}
+
+	sm := &stagedSecretsManager{previous: "v1"}
+
+	r := &AWSSecretController{
+		Client:      &applyingClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build()},
+		Scheme:      scheme,
+		SyncContext: &SyncContext{sm: sm},
+	}
+
+	reconcileStages := func() *mumoshuv1alpha1.AWSSecret {
+		t.Helper()
+
+		var got mumoshuv1alpha1.AWSSecret
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.reconcileStageSecrets(ctx, logr.Discard(), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		return &got
+	}
+
+	if got := reconcileStages(); got.Status.StageSecretVersions != "stringDataFrom_previous=v1" {
+		t.Errorf("unexpected stage secret versions: %q", got.Status.StageSecretVersions)
+	}
+
+	var secret corev1.Secret
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example-previous"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// The versions are unchanged, so the secret values are not read again
+	reconcileStages()
+
+	if sm.reads != 1 {
+		t.Errorf("want the previous version read once, got %d reads", sm.reads)
+	}
+
+	sm.previous = "v2"
+
+	if got := reconcileStages(); sm.reads != 2 || got.Status.StageSecretVersions != "stringDataFrom_previous=v2" {
+		t.Errorf("want the new previous version read, got %d reads and stage secret versions %q", sm.reads, got.Status.StageSecretVersions)
+	}
+}
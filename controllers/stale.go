@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultStalenessCheckInterval is the interval of the staleness checks of pinned versions when StalenessCheckInterval is omitted
+const defaultStalenessCheckInterval = time.Hour
+
+// versionStaleness is the state of a pinned version among the versions of the Secrets Manager secret
+type versionStaleness struct {
+	// Found is false when the version no longer exists
+	Found bool
+	// Stages are the staging labels of the version
+	Stages []string
+	// Behind is the number of versions created after the version
+	Behind int
+}
+
+// reason returns the reason of the VersionStale condition, or ReasonVersionCurrent when the version isn't stale
+func (s versionStaleness) reason() string {
+	switch {
+	case !s.Found:
+		return mumoshuv1alpha1.ReasonVersionDeleted
+	case len(s.Stages) == 0:
+		return mumoshuv1alpha1.ReasonVersionDeprecated
+	}
+
+	for _, st := range s.Stages {
+		if st == stageCurrent {
+			return mumoshuv1alpha1.ReasonVersionCurrent
+		}
+	}
+
+	return mumoshuv1alpha1.ReasonVersionNotCurrent
+}
+
+func (s versionStaleness) message(ref mumoshuv1alpha1.SecretsManagerSecretRef) string {
+	switch s.reason() {
+	case mumoshuv1alpha1.ReasonVersionDeleted:
+		return fmt.Sprintf("version %s of %s no longer exists", ref.VersionId, ref.SecretId)
+	case mumoshuv1alpha1.ReasonVersionDeprecated:
+		return fmt.Sprintf("version %s of %s has no staging labels and can be deleted at any time, %d versions behind", ref.VersionId, ref.SecretId, s.Behind)
+	}
+
+	return fmt.Sprintf("version %s of %s is %s, %d versions behind", ref.VersionId, ref.SecretId, strings.Join(s.Stages, ","), s.Behind)
+}
+
+// stalenessOf returns the state of the version among the versions of the secret
+func stalenessOf(versions []*secretsmanager.SecretVersionsListEntry, versionId string) versionStaleness {
+	var pinned *secretsmanager.SecretVersionsListEntry
+	for _, v := range versions {
+		if aws.StringValue(v.VersionId) == versionId {
+			pinned = v
+			break
+		}
+	}
+
+	if pinned == nil {
+		return versionStaleness{}
+	}
+
+	s := versionStaleness{Found: true, Stages: aws.StringValueSlice(pinned.VersionStages)}
+
+	for _, v := range versions {
+		if v.CreatedDate != nil && pinned.CreatedDate != nil && v.CreatedDate.After(*pinned.CreatedDate) {
+			s.Behind++
+		}
+	}
+
+	return s
+}
+
+// stalenessCheckDue returns true when the pinned versions haven't been checked since the spec changed,
+// or for the staleness check interval
+func (r *AWSSecretController) stalenessCheckDue(cr *mumoshuv1alpha1.AWSSecret, now time.Time) bool {
+	c := meta.FindStatusCondition(cr.Status.Conditions, mumoshuv1alpha1.ConditionVersionStale)
+	if c == nil || c.ObservedGeneration != cr.Generation || cr.Status.VersionsCheckedAt == nil {
+		return true
+	}
+
+	return !now.Before(cr.Status.VersionsCheckedAt.Add(r.stalenessCheckInterval()))
+}
+
+func (r *AWSSecretController) stalenessCheckInterval() time.Duration {
+	if r.StalenessCheckInterval > 0 {
+		return r.StalenessCheckInterval
+	}
+	return defaultStalenessCheckInterval
+}
+
+// reconcileVersionStaleness sets the VersionStale condition and metrics of the AWSSecret according to
+// whether the pinned versions are still AWSCURRENT, and emits an Event when a pinned version becomes stale.
+// Secrets Manager deprecates versions without staging labels and can delete them, which would break the AWSSecret.
+// The versions are listed only when the check is due, so that resyncs of unchanged AWSSecrets make no Secrets Manager API calls.
+func (r *AWSSecretController) reconcileVersionStaleness(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret) error {
+	now := time.Now()
+	if !r.stalenessCheckDue(cr, now) {
+		return nil
+	}
+
+	checkedAt := metav1.NewTime(now)
+
+	sources := []struct {
+		name string
+		ref  mumoshuv1alpha1.SecretsManagerSecretRef
+	}{
+		{name: "stringDataFrom", ref: cr.Spec.StringDataFrom.SecretsManagerSecretRef},
+		{name: "dataFrom", ref: cr.Spec.DataFrom.SecretsManagerSecretRef},
+	}
+
+	var (
+		pinned   bool
+		reason   = mumoshuv1alpha1.ReasonVersionCurrent
+		messages []string
+	)
+
+	for _, s := range sources {
+		if s.ref.SecretId == "" || s.ref.VersionId == "" {
+			deleteVersionMetrics(cr.Namespace, cr.Name, s.name)
+			continue
+		}
+
+		pinned = true
+
+		versions, err := r.SyncContext.ListSecretVersions(s.ref.SecretId)
+		if err != nil {
+			return err
+		}
+
+		st := stalenessOf(versions, s.ref.VersionId)
+
+		stale := 0.0
+		if st.reason() != mumoshuv1alpha1.ReasonVersionCurrent {
+			stale = 1
+			reason = st.reason()
+			messages = append(messages, st.message(s.ref))
+		}
+
+		versionStale.WithLabelValues(cr.Namespace, cr.Name, s.name).Set(stale)
+		versionsBehind.WithLabelValues(cr.Namespace, cr.Name, s.name).Set(float64(st.Behind))
+	}
+
+	if !pinned {
+		return r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+			meta.RemoveStatusCondition(&st.Conditions, mumoshuv1alpha1.ConditionVersionStale)
+			st.VersionsCheckedAt = nil
+		})
+	}
+
+	if len(messages) == 0 {
+		return r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+			setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionVersionStale, metav1.ConditionFalse, reason, "The pinned versions are AWSCURRENT")
+			st.VersionsCheckedAt = &checkedAt
+		})
+	}
+
+	message := "Pinned " + strings.Join(messages, "; ")
+
+	if c := meta.FindStatusCondition(cr.Status.Conditions, mumoshuv1alpha1.ConditionVersionStale); c == nil || c.Status != metav1.ConditionTrue || c.Message != message {
+		reqLogger.Info("Pinned version is stale", "reason", reason, "message", message)
+		r.event(cr, corev1.EventTypeWarning, mumoshuv1alpha1.ConditionVersionStale, message)
+	}
+
+	return r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		setCondition(cr, &st.Conditions, mumoshuv1alpha1.ConditionVersionStale, metav1.ConditionTrue, reason, message)
+		st.VersionsCheckedAt = &checkedAt
+	})
+}
+
+// event records an Event on the AWSSecret, when the controller has a recorder
+func (r *AWSSecretController) event(cr *mumoshuv1alpha1.AWSSecret, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+
+	r.Recorder.Event(cr, eventType, reason, message)
+}
+
+// ListSecretVersions returns all the versions of the SecretsManager secret, including the deprecated ones without staging labels
+func (c *SyncContext) ListSecretVersions(secretId string) ([]*secretsmanager.SecretVersionsListEntry, error) {
+	var versions []*secretsmanager.SecretVersionsListEntry
+
+	err := c.client().ListSecretVersionIdsPages(&secretsmanager.ListSecretVersionIdsInput{
+		SecretId:          &secretId,
+		IncludeDeprecated: aws.Bool(true),
+	}, func(page *secretsmanager.ListSecretVersionIdsOutput, _ bool) bool {
+		versions = append(versions, page.Versions...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStalenessOf(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	version := func(id string, created time.Time, stages ...string) *secretsmanager.SecretVersionsListEntry {
+		return &secretsmanager.SecretVersionsListEntry{
+			VersionId:     aws.String(id),
+			CreatedDate:   aws.Time(created),
+			VersionStages: aws.StringSlice(stages),
+		}
+	}
+
+	versions := []*secretsmanager.SecretVersionsListEntry{
+		version("v1", t0),
+		version("v2", t0.Add(time.Hour), "AWSPREVIOUS"),
+		version("v3", t0.Add(2*time.Hour), "AWSCURRENT"),
+	}
+
+	type testcase struct {
+		versionId  string
+		wantReason string
+		wantBehind int
+	}
+
+	testcases := []testcase{
+		{versionId: "v3", wantReason: mumoshuv1alpha1.ReasonVersionCurrent, wantBehind: 0},
+		{versionId: "v2", wantReason: mumoshuv1alpha1.ReasonVersionNotCurrent, wantBehind: 1},
+		{versionId: "v1", wantReason: mumoshuv1alpha1.ReasonVersionDeprecated, wantBehind: 2},
+		{versionId: "v0", wantReason: mumoshuv1alpha1.ReasonVersionDeleted, wantBehind: 0},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.versionId, func(t *testing.T) {
+			got := stalenessOf(versions, tc.versionId)
+			if got.reason() != tc.wantReason || got.Behind != tc.wantBehind {
+				t.Errorf("want reason=%s behind=%d, got reason=%s behind=%d", tc.wantReason, tc.wantBehind, got.reason(), got.Behind)
+			}
+		})
+	}
+}
+
+// versionListingSecretsManager lists v1 as the AWSCURRENT version, and counts the calls
+type versionListingSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	calls int
+}
+
+func (f *versionListingSecretsManager) ListSecretVersionIdsPages(_ *secretsmanager.ListSecretVersionIdsInput, fn func(*secretsmanager.ListSecretVersionIdsOutput, bool) bool) error {
+	f.calls++
+
+	fn(&secretsmanager.ListSecretVersionIdsOutput{
+		Versions: []*secretsmanager.SecretVersionsListEntry{
+			{VersionId: aws.String("v1"), VersionStages: aws.StringSlice([]string{"AWSCURRENT"})},
+		},
+	}, true)
+
+	return nil
+}
+
+func TestVersionStalenessCheckInterval(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", Generation: 1},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			StringDataFrom: mumoshuv1alpha1.StringDataFrom{
+				SecretsManagerSecretRef: mumoshuv1alpha1.SecretsManagerSecretRef{SecretId: "prod/mysecret", VersionId: "v1"},
+			},
+		},
+	}
+
+	sm := &versionListingSecretsManager{}
+
+	r := &AWSSecretController{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build(),
+		Scheme:      scheme,
+		SyncContext: &SyncContext{sm: sm},
+	}
+
+	check := func() {
+		t.Helper()
+
+		var got mumoshuv1alpha1.AWSSecret
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.reconcileVersionStaleness(ctx, logr.Discard(), &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+	check()
+
+	if sm.calls != 1 {
+		t.Errorf("want the versions listed once until the check is due, got %d calls", sm.calls)
+	}
+
+	var got mumoshuv1alpha1.AWSSecret
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.VersionsCheckedAt == nil {
+		t.Fatal("want versionsCheckedAt recorded in the status")
+	}
+
+	// A spec change makes the check due
+	got.Generation = 2
+	if err := r.Client.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	check()
+
+	if sm.calls != 2 {
+		t.Errorf("want the versions listed again after a spec change, got %d calls", sm.calls)
+	}
+
+	// So does the end of the interval
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "example"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if r.stalenessCheckDue(&got, time.Now()) {
+		t.Error("want no check due right after a check")
+	}
+	if !r.stalenessCheckDue(&got, got.Status.VersionsCheckedAt.Add(defaultStalenessCheckInterval)) {
+		t.Error("want a check due after the staleness check interval")
+	}
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -196,7 +197,7 @@ func waitForSecret(ctx context.Context, log logr.Logger, client client.Client, d
 			}
 		}
 
-		if want, got := len(labels), len(secret.Labels); want != got {
+		if want, got := len(labels), len(userKeys(secret.Labels)); want != got {
 			log.Info("Still waiting for labels to be updated", "want", want, "got", got, "observed", secret.Labels)
 			return false, nil
 		}
@@ -207,7 +208,7 @@ func waitForSecret(ctx context.Context, log logr.Logger, client client.Client, d
 			}
 		}
 
-		if want, got := len(annotations), len(secret.Annotations); want != got {
+		if want, got := len(annotations), len(userKeys(secret.Annotations)); want != got {
 			log.Info("Still waiting for annotations to be updated", "key", want, "got", got)
 			return false, nil
 		}
@@ -226,3 +227,14 @@ func waitForSecret(ctx context.Context, log logr.Logger, client client.Client, d
 	log.Info("Secret available", "name", name)
 	return nil
 }
+
+// userKeys returns the labels or annotations except the ones the operator sets on its own
+func userKeys(m map[string]string) map[string]string {
+	keys := map[string]string{}
+	for k, v := range m {
+		if !strings.HasPrefix(k, keyPrefix) {
+			keys[k] = v
+		}
+	}
+	return keys
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	errs "github.com/pkg/errors"
+)
+
+const (
+	// defaultHistoryLimit is the number of previous Secret generations retained when HistoryLimit is omitted
+	defaultHistoryLimit = 2
+
+	// pointerConfigMapKey is the key of the pointer ConfigMap that holds the name of the current Secret
+	pointerConfigMapKey = "secretName"
+)
+
+// reconcileVersioned publishes the desired content as an immutable, version-suffixed Secret,
+// points the AWSSecret status and the optional pointer ConfigMap to it, and garbage-collects older generations.
+// The served region is recorded in the status unless empty.
+func (r *AWSSecretController) reconcileVersioned(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret, desired *corev1.Secret, servedRegion string) (reconcile.Result, error) {
+	immutable := true
+	hash := secretDataHash(desired)
+	previousName := cr.Status.CurrentSecretName
+
+	desired.Name = versionedSecretName(cr.Name, hash)
+	desired.Immutable = &immutable
+
+	labels := make(map[string]string, len(desired.Labels)+1)
+	for k, v := range desired.Labels {
+		labels[k] = v
+	}
+	labels[LabelAWSSecret] = truncatedName(cr.Name)
+	desired.Labels = labels
+
+	if cr.Spec.CreationPolicy == mumoshuv1alpha1.CreationPolicyMerge {
+		if err := r.updateStatus(ctx, cr, setReadyCondition(cr, metav1.ConditionFalse, mumoshuv1alpha1.ReasonUnsupportedCreationPolicy,
+			"creationPolicy Merge can't be used with versioned Secrets")); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, nil
+	}
+
+	current := &corev1.Secret{}
+	err := r.getSecret(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, current)
+	if err != nil && errors.IsNotFound(err) {
+		current = nil
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	decision := decideCreation(cr, desired.Name, current)
+	if !decision.Write {
+		if err := r.updateStatus(ctx, cr, setReadyCondition(cr, decision.Status, decision.Reason, decision.Message)); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: r.requeueAfter(cr)}, nil
+	}
+
+	if current == nil {
+		reqLogger.Info("Secret generation does not exist, Creating a new Secret", "desired.Namespace", desired.Namespace, "desired.Name", desired.Name)
+		if err := r.applySecret(ctx, desired); err != nil {
+			return reconcile.Result{}, err
+		}
+	} else if changed := changedMetadata(current, desired); len(changed) > 0 || decision.Reason == mumoshuv1alpha1.ReasonAdopted {
+		// Immutability only applies to the data, so we can still update the metadata in place
+		reqLogger.Info("Detected changes. Updating the Secret", "changed", changed, "desired.Namespace", desired.Namespace, "desired.Name", desired.Name)
+		if err := r.applySecret(ctx, desired); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if name := cr.Spec.Versioned.PointerConfigMapName; name != "" {
+		if err := r.applyPointerConfigMap(ctx, cr, name, desired.Name); err != nil {
+			return reconcile.Result{}, errs.Wrap(err, "failed to update pointer configmap")
+		}
+	}
+
+	// Retried until the restarted hash recorded in the status matches, even after the status points to the new generation
+	restartedHash, err := r.reconcileRestarts(ctx, reqLogger, cr, desired, previousName != "" && previousName != desired.Name)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.updateStatus(ctx, cr, func(st *mumoshuv1alpha1.AWSSecretStatus) {
+		st.CurrentSecretName = desired.Name
+		st.SyncedGeneration = cr.Generation
+		st.RestartedHash = restartedHash
+		if servedRegion != "" {
+			st.ServedRegion = servedRegion
+		}
+		setReadyCondition(cr, decision.Status, decision.Reason, decision.Message)(st)
+	}); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.pruneSecretGenerations(ctx, reqLogger, cr, desired.Name); err != nil {
+		return reconcile.Result{}, errs.Wrap(err, "failed to garbage-collect previous secret generations")
+	}
+
+	return reconcile.Result{RequeueAfter: r.requeueAfter(cr)}, nil
+}
+
+func (r *AWSSecretController) applyPointerConfigMap(ctx context.Context, cr *mumoshuv1alpha1.AWSSecret, name, secretName string) error {
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cr.Namespace,
+		},
+		Data: map[string]string{pointerConfigMapKey: secretName},
+	}
+
+	if err := controllerutil.SetControllerReference(cr, cm, r.Scheme); err != nil {
+		return err
+	}
+
+	return r.Client.Patch(ctx, cm, client.Apply, r.applyOptions()...)
+}
+
+// pruneSecretGenerations deletes previous Secret generations that fell out of the history limit,
+// or that are no longer referenced by any Pod when PruneUnreferenced is enabled.
+func (r *AWSSecretController) pruneSecretGenerations(ctx context.Context, reqLogger logr.Logger, cr *mumoshuv1alpha1.AWSSecret, currentName string) error {
+	var secrets corev1.SecretList
+	// Generations written by older versions of the operator aren't labelled as managed, and therefore missing from the cache
+	if err := r.apiReader().List(ctx, &secrets, client.InNamespace(cr.Namespace), client.MatchingLabels{LabelAWSSecret: truncatedName(cr.Name)}); err != nil {
+		return err
+	}
+
+	var previous []corev1.Secret
+	for _, s := range secrets.Items {
+		if s.Name == currentName || !metav1.IsControlledBy(&s, cr) {
+			continue
+		}
+		previous = append(previous, s)
+	}
+
+	if len(previous) == 0 {
+		return nil
+	}
+
+	// Newer generations first
+	sort.Slice(previous, func(i, j int) bool {
+		a, b := previous[i].CreationTimestamp, previous[j].CreationTimestamp
+		if a.Equal(&b) {
+			return previous[i].Name > previous[j].Name
+		}
+		return b.Before(&a)
+	})
+
+	limit := defaultHistoryLimit
+	if l := cr.Spec.Versioned.HistoryLimit; l != nil {
+		limit = int(*l)
+	}
+
+	var pods []corev1.Pod
+	if cr.Spec.Versioned.PruneUnreferenced {
+		var podList corev1.PodList
+		if err := r.apiReader().List(ctx, &podList, client.InNamespace(cr.Namespace)); err != nil {
+			return err
+		}
+		pods = podList.Items
+	}
+
+	for i := range previous {
+		s := &previous[i]
+
+		prune := i >= limit
+		if !prune && cr.Spec.Versioned.PruneUnreferenced {
+			prune = !podsReferenceSecret(pods, s.Name)
+		}
+
+		if !prune {
+			continue
+		}
+
+		reqLogger.Info("Deleting previous Secret generation", "Secret.Namespace", s.Namespace, "Secret.Name", s.Name)
+		if err := r.Client.Delete(ctx, s); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func podsReferenceSecret(pods []corev1.Pod, name string) bool {
+	for i := range pods {
+		p := &pods[i]
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if podSpecReferencesSecret(&p.Spec, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionedSecretName returns the name of the Secret generation for the given content hash.
+// The name of the AWSSecret is truncated so that the hash suffix always fits in a Secret name.
+func versionedSecretName(name, hash string) string {
+	return fmt.Sprintf("%s-%s", truncateName(name, validation.DNS1123SubdomainMaxLength-len(hash)-1), hash)
+}
+
+// secretDataHash returns a short, stable hash of the Secret content
+func secretDataHash(s *corev1.Secret) string {
+	return dataHash(secretForApply(s).Data)
+}
+
+// dataHash returns a short, stable hash of the data
+func dataHash(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write(data[k])
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
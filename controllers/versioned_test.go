@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	mumoshuv1alpha1 "github.com/mumoshu/aws-secret-operator/api/mumoshu/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestSecretDataHash(t *testing.T) {
+	a := &corev1.Secret{
+		Data:       map[string][]byte{"foo": []byte("FOO")},
+		StringData: map[string]string{"AWSVersionId": "v1"},
+	}
+	b := &corev1.Secret{
+		Data: map[string][]byte{"foo": []byte("FOO"), "AWSVersionId": []byte("v1")},
+	}
+	c := &corev1.Secret{
+		Data: map[string][]byte{"foo": []byte("FOO"), "AWSVersionId": []byte("v2")},
+	}
+
+	if got, want := secretDataHash(a), secretDataHash(b); got != want {
+		t.Errorf("stringData and data with the same content must hash equally: got %s, want %s", got, want)
+	}
+
+	if secretDataHash(b) == secretDataHash(c) {
+		t.Errorf("different versions must hash differently")
+	}
+
+	if got := len(secretDataHash(a)); got != 10 {
+		t.Errorf("unexpected hash length: %d", got)
+	}
+}
+
+func TestPodsReferenceSecret(t *testing.T) {
+	type testcase struct {
+		name string
+		pod  corev1.Pod
+		want bool
+	}
+
+	testcases := []testcase{
+		{
+			name: "volume",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "example-abc"}}},
+			}}},
+			want: true,
+		},
+		{
+			name: "envFrom",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "example-abc"}}}}},
+			}}},
+			want: true,
+		},
+		{
+			name: "env in init container",
+			pod: corev1.Pod{Spec: corev1.PodSpec{InitContainers: []corev1.Container{
+				{Env: []corev1.EnvVar{{ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "example-abc"}}}}}},
+			}}},
+			want: true,
+		},
+		{
+			name: "completed pod",
+			pod: corev1.Pod{
+				Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+					{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "example-abc"}}},
+				}},
+				Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+			},
+		},
+		{
+			name: "other secret",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "example-def"}}},
+			}}},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := podsReferenceSecret([]corev1.Pod{tc.pod}, "example-abc"); got != tc.want {
+				t.Errorf("unexpected result: want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAWSSecretLabelValue(t *testing.T) {
+	if got := truncatedName("example"); got != "example" {
+		t.Errorf("want short names as they are, got %s", got)
+	}
+
+	long := strings.Repeat("a", 100)
+
+	v := truncatedName(long)
+	if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+		t.Errorf("invalid label value %s: %v", v, errs)
+	}
+
+	if v == truncatedName(strings.Repeat("a", 101)) {
+		t.Errorf("want distinct label values for distinct long names")
+	}
+}
+
+func TestVersionedSecretName(t *testing.T) {
+	hash := secretDataHash(&corev1.Secret{Data: map[string][]byte{"value": []byte("v1")}})
+
+	if got, want := versionedSecretName("example", hash), "example-"+hash; got != want {
+		t.Errorf("want short names suffixed as they are, got %s", got)
+	}
+
+	long := strings.Repeat("a", validation.DNS1123SubdomainMaxLength)
+
+	v := versionedSecretName(long, hash)
+	if errs := validation.IsDNS1123Subdomain(v); len(errs) > 0 {
+		t.Errorf("invalid secret name %s: %v", v, errs)
+	}
+
+	if !strings.HasSuffix(v, "-"+hash) {
+		t.Errorf("want the content hash kept as the suffix, got %s", v)
+	}
+
+	if v == versionedSecretName(strings.Repeat("a", validation.DNS1123SubdomainMaxLength-1), hash) {
+		t.Errorf("want distinct secret names for distinct long names")
+	}
+}
+
+func TestPruneSecretGenerationsReadsLivePods(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", UID: "uid"},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			Versioned: &mumoshuv1alpha1.VersionedSecrets{PruneUnreferenced: true},
+		},
+	}
+
+	var objs []client.Object
+	for _, name := range []string{"example-current", "example-used", "example-unused"} {
+		s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{LabelAWSSecret: "example"}}}
+		if err := controllerutil.SetControllerReference(cr, s, scheme); err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, s)
+	}
+
+	// The Pod is not in the cache yet
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "myapp", Namespace: "default"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+			{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "example-used"}}},
+		}},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+
+	r := &AWSSecretController{
+		Client:    c,
+		Scheme:    scheme,
+		APIReader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs, pod)...).Build(),
+	}
+
+	if err := r.pruneSecretGenerations(ctx, logr.Discard(), cr, "example-current"); err != nil {
+		t.Fatal(err)
+	}
+
+	var secrets corev1.SecretList
+	if err := c.List(ctx, &secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, s := range secrets.Items {
+		got = append(got, s.Name)
+	}
+
+	if diff := cmp.Diff([]string{"example-current", "example-used"}, got); diff != "" {
+		t.Errorf("want only the generation unreferenced by live pods pruned (-want +got):\n%s", diff)
+	}
+}
+
+func TestReconcileVersionedRetriesRestarts(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	data := map[string][]byte{"value": []byte("v2")}
+	hash := dataHash(data)
+
+	// The status already points to the new generation, but restarting the workloads for it failed
+	cr := &mumoshuv1alpha1.AWSSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", UID: "uid"},
+		Spec: mumoshuv1alpha1.AWSSecretSpec{
+			Versioned:        &mumoshuv1alpha1.VersionedSecrets{},
+			RestartWorkloads: true,
+		},
+		Status: mumoshuv1alpha1.AWSSecretStatus{
+			CurrentSecretName: versionedSecretName("example", hash),
+			RestartedHash:     "old",
+		},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "myapp", Namespace: "default", Annotations: map[string]string{AnnotationRestartOnChange: "example"}},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr, deployment).Build()
+
+	r := &AWSSecretController{Client: &applyingClient{Client: c}, Scheme: scheme}
+
+	desired := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default"}, Data: data}
+
+	if _, err := r.reconcileVersioned(ctx, logr.Discard(), cr, desired, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var d appsv1.Deployment
+	if err := c.Get(ctx, client.ObjectKeyFromObject(deployment), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Spec.Template.Annotations[checksumAnnotationKey("example")] != hash {
+		t.Errorf("want the deployment restarted for %s, got annotations %v", hash, d.Spec.Template.Annotations)
+	}
+
+	var got mumoshuv1alpha1.AWSSecret
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cr), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.RestartedHash != hash {
+		t.Errorf("want the restarted hash %s recorded, got %q", hash, got.Status.RestartedHash)
+	}
+}
@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// SignatureHeader is the header of the HMAC-SHA256 signature of events delivered directly to the EventReceiver,
+	// in the form of `sha256=<hex>`. The signature covers the timestamp and the body joined with a dot.
+	SignatureHeader = "X-Signature-256"
+
+	// SignatureTimestampHeader is the header of the time events delivered directly to the EventReceiver were signed at,
+	// in Unix seconds
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+
+	// signatureTolerance is how far the signature timestamp may be from the current time,
+	// so that captured requests can't be replayed afterwards
+	signatureTolerance = 5 * time.Minute
+
+	snsMessageTypeHeader = "X-Amz-Sns-Message-Type"
+
+	// maxEventSize is the maximum size of event payloads. EventBridge events are up to 256KB.
+	maxEventSize = 512 * 1024
+)
+
+// snsCertHost matches the hosts SNS signing certificates are served from
+var snsCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+var _ manager.Runnable = &EventReceiver{}
+var _ manager.LeaderElectionRunnable = &EventReceiver{}
+
+// EventReceiver is an HTTP server that receives Secrets Manager change events from EventBridge
+// and enqueues the affected AWSSecrets immediately, instead of waiting for the periodic resync.
+//
+// Events are accepted either as raw EventBridge events signed with the shared HMAC key,
+// or as notifications from the SNS topics subscribed over HTTPS, verified with the SNS signature.
+type EventReceiver struct {
+	// BindAddress is the address the server listens on, like `:8443`
+	BindAddress string
+
+	// TLSCertFile and TLSKeyFile are the paths to the certificate and the key the server serves HTTPS with.
+	// The server serves plain HTTP when empty, for TLS terminated by an Ingress or a load balancer.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// HMACKey is the shared key raw events are signed with. Raw events are rejected when empty.
+	HMACKey []byte
+
+	// SNSTopicARNs are the ARNs of the SNS topics notifications are accepted from. SNS notifications are rejected when empty.
+	SNSTopicARNs []string
+
+	Enqueuer Enqueuer
+	Log      logr.Logger
+
+	// HTTPClient fetches SNS signing certificates and confirms SNS subscriptions
+	HTTPClient *http.Client
+
+	certs sync.Map
+}
+
+// NeedLeaderElection returns false so that every replica behind the Service accepts events.
+// The replicas that don't reconcile the affected AWSSecrets forward them via the Enqueuer.
+func (r *EventReceiver) NeedLeaderElection() bool {
+	return false
+}
+
+// Start runs the HTTP server until the context is done
+func (r *EventReceiver) Start(ctx context.Context) error {
+	if len(r.HMACKey) == 0 && len(r.SNSTopicARNs) == 0 {
+		return errors.New("event receiver requires either the HMAC key or SNS topic ARNs to verify events")
+	}
+
+	srv := &http.Server{
+		Addr:              r.BindAddress,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		r.Log.Info("Starting event receiver", "address", r.BindAddress, "tls", r.TLSCertFile != "")
+		if r.TLSCertFile != "" {
+			errCh <- srv.ListenAndServeTLS(r.TLSCertFile, r.TLSKeyFile)
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+func (r *EventReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxEventSize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	var event []byte
+
+	if req.Header.Get(snsMessageTypeHeader) != "" {
+		event, err = r.receiveSNS(req.Context(), body)
+	} else {
+		event, err = r.receiveSigned(req.Header.Get(SignatureHeader), req.Header.Get(SignatureTimestampHeader), body, time.Now())
+	}
+	if err != nil {
+		r.Log.Info("Rejected event", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if event == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ids, err := secretIdsOfEvent(event)
+	if err != nil {
+		http.Error(w, "malformed event", http.StatusBadRequest)
+		return
+	}
+
+	n, err := r.Enqueuer.Enqueue(req.Context(), ids)
+	if err != nil {
+		r.Log.Error(err, "Failed to enqueue awssecrets", "secretIds", ids)
+		http.Error(w, "failed to enqueue awssecrets", http.StatusInternalServerError)
+		return
+	}
+
+	if n > 0 {
+		r.Log.Info("Enqueued awssecrets for changed secrets", "secretIds", ids, "count", n)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// receiveSigned verifies the HMAC signature and the signature timestamp of a raw EventBridge event
+func (r *EventReceiver) receiveSigned(signature, timestamp string, body []byte, now time.Time) ([]byte, error) {
+	if len(r.HMACKey) == 0 {
+		return nil, errors.New("signed events are not accepted")
+	}
+
+	if !verifyHMAC(r.HMACKey, signature, timestamp, body) {
+		return nil, errors.New("invalid signature")
+	}
+
+	// The timestamp is checked only after the signature, as it can't be trusted before
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("malformed signature timestamp")
+	}
+
+	if d := now.Sub(time.Unix(sec, 0)); d > signatureTolerance || d < -signatureTolerance {
+		return nil, fmt.Errorf("signature timestamp is off by %s", d.Round(time.Second))
+	}
+
+	return body, nil
+}
+
+// verifyHMAC returns true when the signature is the HMAC-SHA256 of the timestamp and the body joined with a dot
+func verifyHMAC(key []byte, signature, timestamp string, body []byte) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// snsMessage is a message delivered by SNS to HTTPS subscriptions.
+// See https://docs.aws.amazon.com/sns/latest/dg/sns-message-and-json-formats.html
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageId        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// receiveSNS verifies the SNS message and returns the EventBridge event it carries.
+// Subscription confirmations are confirmed and yield no event.
+func (r *EventReceiver) receiveSNS(ctx context.Context, body []byte) ([]byte, error) {
+	var m snsMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("malformed sns message: %w", err)
+	}
+
+	if !contains(r.SNSTopicARNs, m.TopicArn) {
+		return nil, fmt.Errorf("sns topic %s is not allowed", m.TopicArn)
+	}
+
+	if err := r.verifySNS(ctx, m); err != nil {
+		return nil, err
+	}
+
+	switch m.Type {
+	case "SubscriptionConfirmation":
+		if err := r.confirmSubscription(ctx, m.SubscribeURL); err != nil {
+			return nil, err
+		}
+		r.Log.Info("Confirmed sns subscription", "topicArn", m.TopicArn)
+		return nil, nil
+	case "Notification":
+		return []byte(m.Message), nil
+	}
+
+	return nil, nil
+}
+
+func (r *EventReceiver) verifySNS(ctx context.Context, m snsMessage) error {
+	var hash crypto.Hash
+
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported sns signature version %q", m.SignatureVersion)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("malformed sns signature: %w", err)
+	}
+
+	cert, err := r.signingCert(ctx, m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("sns signing certificate has no rsa public key")
+	}
+
+	h := hash.New()
+	h.Write(snsStringToSign(m))
+
+	if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig); err != nil {
+		return fmt.Errorf("invalid sns signature: %w", err)
+	}
+
+	return nil
+}
+
+// snsStringToSign returns the string SNS signs for the message type
+func snsStringToSign(m snsMessage) []byte {
+	var fields [][2]string
+
+	if m.Type == "Notification" {
+		fields = [][2]string{{"Message", m.Message}, {"MessageId", m.MessageId}}
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+		fields = append(fields, [][2]string{{"Timestamp", m.Timestamp}, {"TopicArn", m.TopicArn}, {"Type", m.Type}}...)
+	} else {
+		fields = [][2]string{
+			{"Message", m.Message}, {"MessageId", m.MessageId}, {"SubscribeURL", m.SubscribeURL},
+			{"Timestamp", m.Timestamp}, {"Token", m.Token}, {"TopicArn", m.TopicArn}, {"Type", m.Type},
+		}
+	}
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0])
+		b.WriteByte('\n')
+		b.WriteString(f[1])
+		b.WriteByte('\n')
+	}
+
+	return []byte(b.String())
+}
+
+// signingCert fetches and caches the SNS signing certificate, after making sure it is served by SNS
+func (r *EventReceiver) signingCert(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if err := validateSNSURL(certURL); err != nil {
+		return nil, err
+	}
+
+	if c, ok := r.certs.Load(certURL); ok {
+		return c.(*x509.Certificate), nil
+	}
+
+	body, err := r.get(ctx, certURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching sns signing certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("malformed sns signing certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing sns signing certificate: %w", err)
+	}
+
+	r.certs.Store(certURL, cert)
+
+	return cert, nil
+}
+
+func (r *EventReceiver) confirmSubscription(ctx context.Context, subscribeURL string) error {
+	if err := validateSNSURL(subscribeURL); err != nil {
+		return err
+	}
+
+	if _, err := r.get(ctx, subscribeURL); err != nil {
+		return fmt.Errorf("confirming sns subscription: %w", err)
+	}
+
+	return nil
+}
+
+func (r *EventReceiver) get(ctx context.Context, u string) ([]byte, error) {
+	c := r.HTTPClient
+	if c == nil {
+		c = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, maxEventSize))
+}
+
+// validateSNSURL makes sure the URL points to SNS, so that forged messages can't make us trust arbitrary certificates
+func validateSNSURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "https" || !snsCertHost.MatchString(parsed.Hostname()) {
+		return fmt.Errorf("%s is not an sns url", u)
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// signEvent returns the signature of the body signed at the timestamp with the key
+func signEvent(key []byte, timestamp, body string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestEventReceiverRejectsUnsignedEvents(t *testing.T) {
+	r := &EventReceiver{HMACKey: []byte("key"), Log: logr.Discard()}
+
+	body := `{"source":"aws.secretsmanager"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for name, signature := range map[string]string{
+		"missing":   "",
+		"wrong key": signEvent([]byte("other-key"), now, body),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(SignatureTimestampHeader, now)
+		if signature != "" {
+			req.Header.Set(SignatureHeader, signature)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s signature: want status %d, got %d", name, http.StatusUnauthorized, rec.Code)
+		}
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	body := []byte(`{"source":"aws.secretsmanager"}`)
+
+	signature := signEvent([]byte("key"), "1640995200", string(body))
+
+	if !verifyHMAC([]byte("key"), signature, "1640995200", body) {
+		t.Error("expected valid signature")
+	}
+
+	if verifyHMAC([]byte("key"), signature, "1640995200", append(body, ' ')) {
+		t.Error("expected invalid signature for tampered body")
+	}
+
+	if verifyHMAC([]byte("key"), signature, "1640995201", body) {
+		t.Error("expected invalid signature for tampered timestamp")
+	}
+}
+
+func TestReceiveSignedRejectsReplays(t *testing.T) {
+	r := &EventReceiver{HMACKey: []byte("key"), Log: logr.Discard()}
+
+	body := `{"source":"aws.secretsmanager"}`
+	signedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	signature := signEvent([]byte("key"), timestamp, body)
+
+	type testcase struct {
+		name      string
+		timestamp string
+		now       time.Time
+		wantErr   bool
+	}
+
+	testcases := []testcase{
+		{name: "fresh", timestamp: timestamp, now: signedAt.Add(time.Second)},
+		{name: "clock skew", timestamp: timestamp, now: signedAt.Add(-time.Minute)},
+		{name: "replayed", timestamp: timestamp, now: signedAt.Add(signatureTolerance + time.Second), wantErr: true},
+		{name: "from the future", timestamp: timestamp, now: signedAt.Add(-signatureTolerance - time.Second), wantErr: true},
+		{name: "missing timestamp", timestamp: "", now: signedAt, wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := r.receiveSigned(signature, tc.timestamp, []byte(body), tc.now)
+			if tc.wantErr && err == nil {
+				t.Error("expected the event rejected")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifySNS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certURL := "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+
+	r := &EventReceiver{SNSTopicARNs: []string{"arn:aws:sns:us-east-1:123456789012:secrets"}, Log: logr.Discard()}
+	r.certs.Store(certURL, cert)
+
+	m := snsMessage{
+		Type:             "Notification",
+		MessageId:        "id",
+		TopicArn:         "arn:aws:sns:us-east-1:123456789012:secrets",
+		Message:          `{"source":"aws.secretsmanager"}`,
+		Timestamp:        "2022-03-01T00:00:00.000Z",
+		SignatureVersion: "2",
+		SigningCertURL:   certURL,
+	}
+
+	digest := sha256.Sum256(snsStringToSign(m))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	if err := r.verifySNS(context.Background(), m); err != nil {
+		t.Errorf("expected valid signature: %v", err)
+	}
+
+	tampered := m
+	tampered.Message = `{"source":"aws.secretsmanager","detail":{}}`
+	if err := r.verifySNS(context.Background(), tampered); err == nil {
+		t.Error("expected invalid signature for tampered message")
+	}
+
+	forged := m
+	forged.SigningCertURL = "https://attacker.example.com/cert.pem"
+	if err := r.verifySNS(context.Background(), forged); err == nil {
+		t.Error("expected certificates outside of sns to be rejected")
+	}
+}